@@ -0,0 +1,164 @@
+package dojo
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+const accessTokenContextKey = "auth_access_token"
+
+// lastUsedInterval limits the writes of the last used timestamp
+const lastUsedInterval = time.Minute
+
+var (
+	// ErrAccessTokenNotFound is returned by an AccessTokenStore when no token exists for an id.
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrAccessTokenInvalid  = errors.New("access token is invalid")
+	ErrAccessTokenExpired  = errors.New("access token is expired")
+
+	ErrAccessTokensNotConfigured = errors.New("access tokens need a user provider and a token store")
+)
+
+// PersonalAccessToken lets machine clients authenticate as a user. Only a
+// sha256 hash of the secret part is stored.
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	UserID     string
+	Name       string
+	Hash       string
+	Abilities  []string
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Can reports if the token has the ability, wildcards like "posts.*" are supported.
+func (t PersonalAccessToken) Can(ability string) bool {
+	for _, a := range t.Abilities {
+		if MatchPermission(a, ability) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t PersonalAccessToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// AccessTokenStore persists the personal access tokens.
+type AccessTokenStore interface {
+	Create(ctx context.Context, token PersonalAccessToken) error
+	Find(ctx context.Context, id uuid.UUID) (PersonalAccessToken, error)
+	Touch(ctx context.Context, id uuid.UUID, at time.Time) error
+	Revoke(ctx context.Context, id uuid.UUID) error
+	ForUser(ctx context.Context, userID string) ([]PersonalAccessToken, error)
+}
+
+// NewPersonalAccessToken creates a token for the user. The returned plain text
+// token is "<id>|<secret>" and must be shown to the user once, it can't be
+// recovered from the store.
+func NewPersonalAccessToken(userID string, name string, abilities []string, expiresAt *time.Time) (string, PersonalAccessToken, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", PersonalAccessToken{}, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", PersonalAccessToken{}, err
+	}
+
+	token := PersonalAccessToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashAccessTokenSecret(secret),
+		Abilities: abilities,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	return fmt.Sprintf("%s|%s", id, secret), token, nil
+}
+
+// CreateAccessToken creates and stores a token for the user and returns the plain text token.
+func (auth *Authentication) CreateAccessToken(ctx context.Context, user Authenticable, name string, abilities []string, expiresAt *time.Time) (string, PersonalAccessToken, error) {
+	if auth.AccessTokens == nil {
+		return "", PersonalAccessToken{}, ErrAccessTokensNotConfigured
+	}
+	plain, token, err := NewPersonalAccessToken(AuthIdentifier(user).String(), name, abilities, expiresAt)
+	if err != nil {
+		return "", token, err
+	}
+	return plain, token, auth.AccessTokens.Create(ctx, token)
+}
+
+// AuthenticateAccessToken validates the plain text token and sets its owner as
+// the authenticated user of the request.
+func (auth *Authentication) AuthenticateAccessToken(ctx Context, plain string) error {
+	return auth.authenticateAccessToken(ctx, auth.CurrentGuard(ctx), plain)
+}
+
+func (auth *Authentication) authenticateAccessToken(ctx Context, guard *Guard, plain string) error {
+	users := guard.UserProvider()
+	if users == nil || auth.AccessTokens == nil {
+		return ErrAccessTokensNotConfigured
+	}
+
+	parts := strings.SplitN(plain, "|", 2)
+	if len(parts) != 2 {
+		return ErrAccessTokenInvalid
+	}
+	id, err := uuid.FromString(parts[0])
+	if err != nil {
+		return ErrAccessTokenInvalid
+	}
+
+	token, err := auth.AccessTokens.Find(ctx, id)
+	if errors.Is(err, ErrAccessTokenNotFound) {
+		return ErrAccessTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashAccessTokenSecret(parts[1]))) != 1 {
+		return ErrAccessTokenInvalid
+	}
+	if token.Expired() {
+		return ErrAccessTokenExpired
+	}
+
+	user, err := users.RetrieveByID(ctx, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedInterval {
+		if err := auth.AccessTokens.Touch(ctx, token.ID, now); err != nil {
+			return err
+		}
+		token.LastUsedAt = &now
+	}
+
+	guard.SetUser(ctx, user)
+	ctx.Set(accessTokenContextKey, token)
+	return nil
+}
+
+// CurrentAccessToken returns the token the request was authenticated with
+func (auth *Authentication) CurrentAccessToken(ctx Context) (PersonalAccessToken, bool) {
+	token, ok := ctx.Value(accessTokenContextKey).(PersonalAccessToken)
+	return token, ok
+}
+
+func hashAccessTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package dojo
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAccessTokens struct {
+	tokens  map[uuid.UUID]PersonalAccessToken
+	touches int
+}
+
+func (s *testAccessTokens) Create(ctx context.Context, token PersonalAccessToken) error {
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *testAccessTokens) Find(ctx context.Context, id uuid.UUID) (PersonalAccessToken, error) {
+	token, ok := s.tokens[id]
+	if !ok {
+		return token, ErrAccessTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *testAccessTokens) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	s.touches++
+	token := s.tokens[id]
+	token.LastUsedAt = &at
+	s.tokens[id] = token
+	return nil
+}
+
+func (s *testAccessTokens) Revoke(ctx context.Context, id uuid.UUID) error {
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *testAccessTokens) ForUser(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	var tokens []PersonalAccessToken
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func TestNewPersonalAccessToken(t *testing.T) {
+	plain, token, err := NewPersonalAccessToken("42", "ci", []string{"posts.*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(plain, "|", 2)
+	if len(parts) != 2 || parts[0] != token.ID.String() || token.Hash != hashAccessTokenSecret(parts[1]) {
+		t.Fatalf("expected <id>|<secret> with the hash of the secret, got %q %+v", plain, token)
+	}
+	if !token.Can("posts.create") || token.Can("users.delete") {
+		t.Error("expected the abilities to match with wildcards")
+	}
+	if token.Expired() {
+		t.Error("a token without expiry must not expire")
+	}
+	past := time.Now().Add(-time.Minute)
+	if token.ExpiresAt = &past; !token.Expired() {
+		t.Error("expected the token to be expired")
+	}
+}
+
+func TestAuthentication_AuthenticateAccessToken(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	store := &testAccessTokens{tokens: map[uuid.UUID]PersonalAccessToken{}}
+	user := &testBrokerUser{AuthUser: AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}}
+	app.Auth.Users = &testBrokerUsers{user: user}
+	app.Auth.AccessTokens = store
+
+	plain, token, err := app.Auth.CreateAccessToken(context.Background(), user, "ci", []string{"posts.read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	expiredPlain, expired, _ := NewPersonalAccessToken(token.UserID, "old", nil, &past)
+	_ = store.Create(context.Background(), expired)
+
+	newCtx := func() Context {
+		return app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	invalid := map[string]error{
+		"garbage":           ErrAccessTokenInvalid,
+		"not-a-uuid|secret": ErrAccessTokenInvalid,
+		uuid.Must(uuid.NewV4()).String() + "|secret": ErrAccessTokenInvalid,
+		token.ID.String() + "|wrong":                 ErrAccessTokenInvalid,
+		expiredPlain:                                 ErrAccessTokenExpired,
+	}
+	for value, want := range invalid {
+		if err := app.Auth.AuthenticateAccessToken(newCtx(), value); err != want {
+			t.Errorf("%q: expected %v, got %v", value, want, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx := newCtx()
+		if err := app.Auth.AuthenticateAccessToken(ctx, plain); err != nil {
+			t.Fatal(err)
+		}
+		if current := app.Auth.GetAuthUser(ctx); current.Identifier != user.Identifier {
+			t.Fatalf("expected the owner of the token, got %v", current.Identifier)
+		}
+		if current, ok := app.Auth.CurrentAccessToken(ctx); !ok || current.ID != token.ID || !ctx.TokenCan("posts.read") {
+			t.Fatalf("expected the token on the context, got %+v", current)
+		}
+	}
+	if store.touches != 1 {
+		t.Errorf("expected the last use to be written once per interval, got %d", store.touches)
+	}
+}
+
+func TestAuthentication_AccessTokensNeedAStore(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	if _, _, err := app.Auth.CreateAccessToken(context.Background(), &AuthUser{}, "ci", nil, nil); err != ErrAccessTokensNotConfigured {
+		t.Errorf("expected ErrAccessTokensNotConfigured, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package dojo
 
 import (
 	"fmt"
+	"html/template"
 	"os"
 	"strings"
 )
@@ -12,6 +13,32 @@ type Asset struct {
 	Name      string
 	Extension FileExtension
 	Path      string
+	// Nonce is the content security policy nonce of the request, the views set it
+	Nonce string
+}
+
+// Tag returns the script or stylesheet tag of the asset with the nonce of the request
+func (a Asset) Tag() template.HTML {
+	nonce := ""
+	if a.Nonce != "" {
+		nonce = fmt.Sprintf(` nonce="%s"`, template.HTMLEscapeString(a.Nonce))
+	}
+	src := template.HTMLEscapeString(a.Path)
+	switch a.Extension {
+	case Javascript:
+		return template.HTML(fmt.Sprintf(`<script src="%s"%s></script>`, src, nonce))
+	case Stylesheet:
+		return template.HTML(fmt.Sprintf(`<link rel="stylesheet" href="%s"%s>`, src, nonce))
+	}
+	return ""
+}
+
+// withNonce returns the assets with the nonce of the request
+func withNonce(assets []Asset, nonce string) []Asset {
+	for i := range assets {
+		assets[i].Nonce = nonce
+	}
+	return assets
 }
 
 type FileExtension string
@@ -18,3 +18,15 @@ func TestApplication_Assets(t *testing.T) {
 		}
 	}
 }
+
+func TestAsset_Tag(t *testing.T) {
+	script := Asset{Name: "index", Extension: Javascript, Path: "/assets/index.js", Nonce: "abc"}
+	if got := string(script.Tag()); got != `<script src="/assets/index.js" nonce="abc"></script>` {
+		t.Errorf("unexpected script tag %s", got)
+	}
+
+	style := Asset{Name: "app", Extension: Stylesheet, Path: "/assets/app.css"}
+	if got := string(style.Tag()); got != `<link rel="stylesheet" href="/assets/app.css">` {
+		t.Errorf("unexpected stylesheet tag %s", got)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/zengineDev/x/utilsx"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 	"strings"
 )
 
@@ -41,7 +42,9 @@ type PasswordConfig struct {
 }
 
 const authUserSessionKey = "auth_user"
+const authUserContextKey = "auth_user"
 const oauthStateSessionKey = "oauth_state"
+const intendedURLSessionKey = "url_intended"
 
 type AuthUserType string
 
@@ -58,19 +61,30 @@ type Authenticable interface {
 }
 
 type AuthUser struct {
-	ID   uuid.UUID
-	Data interface{}
+	// Identifier is the int64, string or uuid primary key of the user
+	Identifier Identifier
+	Data       interface{}
+}
+
+// NewAuthUser copies the id and data of the user into an AuthUser for the session
+func NewAuthUser(user Authenticable) AuthUser {
+	return AuthUser{Identifier: AuthIdentifier(user), Data: user.GetAuthData()}
 }
 
 func (u *AuthUser) GetAuthType() AuthUserType {
-	if u.ID == uuid.Nil {
+	if u.GetAuthIdentifier().IsZero() {
 		return GuestUserType
 	}
 	return UserUserType
 }
 
+// GetAuthID returns the uuid of the user, uuid.Nil when its primary key isn't a uuid
 func (u *AuthUser) GetAuthID() uuid.UUID {
-	return u.ID
+	return u.Identifier.UUID
+}
+
+func (u *AuthUser) GetAuthIdentifier() Identifier {
+	return u.Identifier
 }
 
 func (u *AuthUser) GetAuthData() interface{} {
@@ -82,49 +96,88 @@ func (u *AuthUser) IsGuest() bool {
 }
 
 type Authentication struct {
-	dojo *Dojo
+	dojo           *Dojo
+	Throttle       *LoginThrottle
+	Users          UserProvider
+	RememberTokens RememberTokenStore
+	Roles          RoleProvider
+	AccessTokens   AccessTokenStore
+	TwoFactorSteps TwoFactorStepStore
+	Broker         *Broker
+	guards         map[string]*Guard
 }
 
 func NewAuthentication(dojo *Dojo) *Authentication {
 	gob.Register(AuthUser{})
 	gob.Register(map[string]interface{}{})
-	return &Authentication{dojo: dojo}
+	auth := &Authentication{
+		dojo:           dojo,
+		Throttle:       NewLoginThrottle(dojo.Configuration.Auth.Throttle, newThrottleStore(dojo)),
+		TwoFactorSteps: NewMemoryTwoFactorStepStore(),
+		guards:         make(map[string]*Guard),
+	}
+	auth.Broker = NewBroker(auth)
+	auth.DefineGuard(DefaultGuardName, SessionGuardDriver)
+	for name, cfg := range dojo.Configuration.Auth.Guards {
+		driver := cfg.Driver
+		if driver == "" {
+			driver = SessionGuardDriver
+		}
+		auth.DefineGuard(name, driver)
+	}
+	return auth
 }
 
+// GetAuthUser returns the user of the guard of the request
 func (auth *Authentication) GetAuthUser(ctx Context) AuthUser {
-	session := auth.dojo.getSession(ctx.Request(), ctx.Response())
-	sessionData := session.Get(authUserSessionKey)
-	if sessionData == nil {
-		return AuthUser{
-			ID:   uuid.Nil,
-			Data: nil,
-		}
-	}
-	return sessionData.(AuthUser)
+	return auth.CurrentGuard(ctx).User(ctx)
 }
 
 func (auth *Authentication) Login(ctx Context, user Authenticable) error {
-	session := auth.dojo.getSession(ctx.Request(), ctx.Response())
-	session.Set(authUserSessionKey, AuthUser{
-		ID:   user.GetAuthID(),
-		Data: user.GetAuthData(),
-	})
-	return session.Save()
+	return auth.CurrentGuard(ctx).Login(ctx, user)
+}
+
+// SetUser sets the authenticated user for the current request only.
+// It is used by the stateless authentication middlewares like the jwt middleware.
+func (auth *Authentication) SetUser(ctx Context, user Authenticable) {
+	auth.CurrentGuard(ctx).SetUser(ctx, user)
 }
 
+// Logout logs the user of the guard of the request out, the users of the
+// other guards stay logged in. The remember me cookie belongs to the default
+// guard and is forgotten with it.
 func (auth *Authentication) Logout(ctx Context) error {
-	session := auth.dojo.getSession(ctx.Request(), ctx.Response())
-	session.Clear()
-	session.Set(authUserSessionKey, AuthUser{
-		ID: uuid.Nil,
-	})
+	guard := auth.CurrentGuard(ctx)
+	if guard.Name == DefaultGuardName {
+		if err := auth.forgetRemember(ctx); err != nil {
+			return err
+		}
+	}
+	return guard.Logout(ctx)
+}
+
+// SetIntendedURL remembers the url a guest tried to visit, to redirect there after the login.
+func (auth *Authentication) SetIntendedURL(ctx Context, url string) error {
+	session := ctx.Session()
+	session.Set(intendedURLSessionKey, url)
 	return session.Save()
 }
 
+// IntendedURL returns and forgets the remembered url, the fallback is returned when there is none.
+func (auth *Authentication) IntendedURL(ctx Context, fallback string) string {
+	session := ctx.Session()
+	url, ok := session.GetOnce(intendedURLSessionKey).(string)
+	if !ok || url == "" {
+		return fallback
+	}
+	_ = session.Save()
+	return url
+}
+
 func (auth *Authentication) GetAuthorizationUri(ctx Context) string {
 	cfg := auth.dojo.Configuration.Auth
 	state := utilsx.RandomString(16)
-	session := auth.dojo.getSession(ctx.Request(), ctx.Response())
+	session := ctx.Session()
 	session.Set(oauthStateSessionKey, state)
 	return fmt.Sprintf("%s?response_type=%s&client_id=%sredirect_uri=%s&scope=%s&state=%s",
 		fmt.Sprintf("%s/auhtorize", cfg.Endpoint),
@@ -153,7 +206,7 @@ type ExchangeAuthorisationCodeRequest struct {
 }
 
 func (auth Authentication) CompareOAuthState(ctx Context, state string) error {
-	session := auth.dojo.getSession(ctx.Request(), ctx.Response())
+	session := ctx.Session()
 	sessionState := session.Get(oauthStateSessionKey)
 	if fmt.Sprintf("%s", sessionState) != state {
 		return errors.New("oauth state dont match")
@@ -173,6 +226,7 @@ func (auth *Authentication) ExchangeAuthorisationCode(ctx Context, authorisation
 	}
 	client := resty.New()
 	resp, err := client.R().
+		SetContext(ctx).
 		EnableTrace().
 		SetHeader("Content-Type", "application/json").
 		SetBody(body).
@@ -207,11 +261,23 @@ func (auth Authentication) GeneratePasswordHash(c *PasswordConfig, password stri
 	return full, nil
 }
 
+// ComparePasswordAndHash compares the password with an argon2id or a bcrypt hash
 func (auth Authentication) ComparePasswordAndHash(password, hash string) (match bool, err error) {
+	if isBcryptHash(hash) {
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
 	match, _, err = checkHash(password, hash)
 	return match, err
 }
 
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
 func generateRandomBytes(n uint32) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
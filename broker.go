@@ -0,0 +1,348 @@
+package dojo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultPasswordResetLifetime = time.Hour
+	defaultVerificationLifetime  = time.Hour
+)
+
+var (
+	// ErrBrokerTokenNotFound is returned by a BrokerTokenStore when no token exists for a hash.
+	ErrBrokerTokenNotFound = errors.New("broker token not found")
+	ErrBrokerTokenInvalid  = errors.New("the link is invalid or expired")
+
+	ErrBrokerNotConfigured = errors.New("the broker needs a user provider, a mailer and the update callbacks")
+	ErrNoEmailAddress      = errors.New("the user has no email address")
+	// ErrNoPasswordHash is returned when a signed reset link is issued for a user
+	// that doesn't implement PasswordHolder.
+	ErrNoPasswordHash = errors.New("signed reset links need users that implement PasswordHolder")
+)
+
+const passwordStateParam = "state"
+
+// TokenPurpose scopes a broker token to one flow
+type TokenPurpose string
+
+const (
+	PasswordResetPurpose     TokenPurpose = "password_reset"
+	EmailVerificationPurpose TokenPurpose = "email_verification"
+)
+
+// BrokerToken is a single use token of the password reset or email
+// verification flow. Only the sha256 hash of the token is stored.
+type BrokerToken struct {
+	Hash      string
+	UserID    string
+	Purpose   TokenPurpose
+	ExpiresAt time.Time
+}
+
+// BrokerTokenStore persists the broker tokens. Create replaces the existing
+// tokens of the user for the same purpose.
+type BrokerTokenStore interface {
+	Create(ctx context.Context, token BrokerToken) error
+	Find(ctx context.Context, hash string) (BrokerToken, error)
+	Delete(ctx context.Context, hash string) error
+}
+
+// EmailUserProvider is implemented by a UserProvider that can look up users
+// by their email. RetrieveByEmail returns a nil user without error when no
+// user has the email.
+type EmailUserProvider interface {
+	RetrieveByEmail(ctx context.Context, email string) (Authenticable, error)
+}
+
+// MailRecipient is implemented by the user or its data to tell where the mails go to
+type MailRecipient interface {
+	GetEmail() string
+}
+
+// MustVerifyEmail is implemented by the user or its data to tell if the email address was verified
+type MustVerifyEmail interface {
+	HasVerifiedEmail() bool
+}
+
+// PasswordHolder is implemented by the user or its data to expose the stored
+// password hash. Signed reset links are bound to it, so they work only once.
+type PasswordHolder interface {
+	GetAuthPassword() string
+}
+
+// Broker runs the password reset and email verification flows. The links
+// carry a single use token when a token store is set, otherwise they are
+// signed urls. Signed reset links stop working once the password changed,
+// verification links stay valid until they expire.
+type Broker struct {
+	auth   *Authentication
+	Tokens BrokerTokenStore
+	Mailer Mailer
+
+	// UpdatePassword stores the new password hash of the user
+	UpdatePassword func(ctx context.Context, user Authenticable, hash string) error
+	// MarkEmailVerified stores that the user verified the email address
+	MarkEmailVerified func(ctx context.Context, user Authenticable) error
+
+	ResetPasswordPath      string
+	VerifyEmailPath        string
+	VerificationNoticePath string
+	VerificationSendPath   string
+	LoginPath              string
+	HomePath               string
+}
+
+func NewBroker(auth *Authentication) *Broker {
+	return &Broker{
+		auth:                   auth,
+		Mailer:                 &LogMailer{Logger: auth.dojo.Logger},
+		ResetPasswordPath:      "/reset-password",
+		VerifyEmailPath:        "/email/verify",
+		VerificationNoticePath: "/email/verification-notice",
+		VerificationSendPath:   "/email/verification-notification",
+		LoginPath:              "/login",
+		HomePath:               "/",
+	}
+}
+
+func (b *Broker) config() BrokerConfig {
+	cfg := b.auth.dojo.Configuration.Auth.Broker
+	if cfg.PasswordResetLifetime <= 0 {
+		cfg.PasswordResetLifetime = defaultPasswordResetLifetime
+	}
+	if cfg.VerificationLifetime <= 0 {
+		cfg.VerificationLifetime = defaultVerificationLifetime
+	}
+	return cfg
+}
+
+// SendPasswordResetLink mails a reset link to the user with the email. No
+// error is returned for unknown addresses or users without an address, so
+// the response can't be used to find out which addresses have an account.
+// The errors of the provider and the mailer are returned.
+func (b *Broker) SendPasswordResetLink(ctx context.Context, email string) error {
+	provider, ok := b.auth.Users.(EmailUserProvider)
+	if !ok || b.Mailer == nil || b.UpdatePassword == nil {
+		return ErrBrokerNotConfigured
+	}
+	user, err := provider.RetrieveByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	to := emailOf(user)
+	if to == "" {
+		return nil
+	}
+
+	link, err := b.issue(ctx, user, PasswordResetPurpose, b.ResetPasswordPath, b.config().PasswordResetLifetime)
+	if err != nil {
+		return err
+	}
+	return b.Mailer.Send(ctx, MailMessage{
+		To:      to,
+		Subject: "Reset your password",
+		Text: fmt.Sprintf("You asked to reset your password, open the link to choose a new one:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't ask for it, no further action is required.",
+			link, b.config().PasswordResetLifetime),
+	})
+}
+
+// ResetPassword validates the token of the reset link and stores the new
+// password. The remember me tokens of the user are revoked.
+func (b *Broker) ResetPassword(ctx Context, token, password string) error {
+	if b.UpdatePassword == nil || b.auth.Users == nil {
+		return ErrBrokerNotConfigured
+	}
+	user, err := b.redeem(ctx, token, PasswordResetPurpose)
+	if err != nil {
+		return err
+	}
+	hash, err := b.auth.GeneratePasswordHash(DefaultConfigs, password)
+	if err != nil {
+		return err
+	}
+	if err := b.UpdatePassword(ctx, user, hash); err != nil {
+		return err
+	}
+	if b.auth.RememberTokens != nil {
+		return b.auth.RememberTokens.DeleteForUser(ctx, AuthIdentifier(user).String())
+	}
+	return nil
+}
+
+// SendVerificationLink mails the email verification link to the user
+func (b *Broker) SendVerificationLink(ctx context.Context, user Authenticable) error {
+	if b.Mailer == nil || b.MarkEmailVerified == nil {
+		return ErrBrokerNotConfigured
+	}
+	email := emailOf(user)
+	if email == "" {
+		return ErrNoEmailAddress
+	}
+
+	link, err := b.issue(ctx, user, EmailVerificationPurpose, b.VerifyEmailPath, b.config().VerificationLifetime)
+	if err != nil {
+		return err
+	}
+	return b.Mailer.Send(ctx, MailMessage{
+		To:      email,
+		Subject: "Verify your email address",
+		Text:    fmt.Sprintf("Open the link to verify your email address:\n\n%s", link),
+	})
+}
+
+// VerifyEmail validates the verification link of the request, marks the email
+// as verified and returns the verified user.
+func (b *Broker) VerifyEmail(ctx Context, token string) (Authenticable, error) {
+	if b.MarkEmailVerified == nil || b.auth.Users == nil {
+		return nil, ErrBrokerNotConfigured
+	}
+	user, err := b.redeem(ctx, token, EmailVerificationPurpose)
+	if err != nil {
+		return nil, err
+	}
+	return user, b.MarkEmailVerified(ctx, user)
+}
+
+// issue creates the link of the flow, with a stored token or as signed url
+func (b *Broker) issue(ctx context.Context, user Authenticable, purpose TokenPurpose, path string, lifetime time.Duration) (string, error) {
+	expires := time.Now().Add(lifetime)
+	userID := AuthIdentifier(user).String()
+
+	if b.Tokens == nil {
+		params := url.Values{}
+		params.Set("user", userID)
+		params.Set("purpose", string(purpose))
+		if purpose == PasswordResetPurpose {
+			hash, ok := passwordOf(user)
+			if !ok {
+				return "", ErrNoPasswordHash
+			}
+			params.Set(passwordStateParam, b.passwordState(hash))
+		}
+		return b.auth.dojo.SignedURL(path, params, expires)
+	}
+
+	plain, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = b.Tokens.Create(ctx, BrokerToken{
+		Hash:      hashBrokerToken(plain),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s?token=%s", b.auth.dojo.Configuration.App.Domain, path, url.QueryEscape(plain)), nil
+}
+
+// redeem validates the token, or the signature of the request url when no
+// store is set, and returns its user. Stored tokens can't be used twice and
+// signed reset links only work until the password changed.
+func (b *Broker) redeem(ctx Context, plain string, purpose TokenPurpose) (Authenticable, error) {
+	if b.Tokens == nil {
+		return b.redeemSigned(ctx, purpose)
+	}
+
+	if plain == "" {
+		return nil, ErrBrokerTokenInvalid
+	}
+	hash := hashBrokerToken(plain)
+	token, err := b.Tokens.Find(ctx, hash)
+	if errors.Is(err, ErrBrokerTokenNotFound) {
+		return nil, ErrBrokerTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A token of another flow is left for the flow it belongs to
+	if token.Purpose != purpose {
+		return nil, ErrBrokerTokenInvalid
+	}
+	if err := b.Tokens.Delete(ctx, hash); err != nil {
+		return nil, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrBrokerTokenInvalid
+	}
+	return b.auth.Users.RetrieveByID(ctx, token.UserID)
+}
+
+func (b *Broker) redeemSigned(ctx Context, purpose TokenPurpose) (Authenticable, error) {
+	req := ctx.Request()
+	q := req.URL.Query()
+	if !b.auth.dojo.HasValidSignature(req) || q.Get("purpose") != string(purpose) {
+		return nil, ErrBrokerTokenInvalid
+	}
+	user, err := b.auth.Users.RetrieveByID(ctx, q.Get("user"))
+	if err != nil {
+		return nil, err
+	}
+	if purpose != PasswordResetPurpose {
+		return user, nil
+	}
+
+	hash, ok := passwordOf(user)
+	if !ok || !hmac.Equal([]byte(q.Get(passwordStateParam)), []byte(b.passwordState(hash))) {
+		return nil, ErrBrokerTokenInvalid
+	}
+	return user, nil
+}
+
+// passwordState binds a signed reset link to the current password hash
+func (b *Broker) passwordState(hash string) string {
+	mac := hmac.New(sha256.New, []byte(b.auth.dojo.Configuration.Session.Secret))
+	mac.Write([]byte(string(PasswordResetPurpose) + ":" + hash))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func hashBrokerToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func emailOf(user Authenticable) string {
+	if r, ok := user.(MailRecipient); ok {
+		return r.GetEmail()
+	}
+	if r, ok := user.GetAuthData().(MailRecipient); ok {
+		return r.GetEmail()
+	}
+	return ""
+}
+
+func passwordOf(user Authenticable) (string, bool) {
+	if p, ok := user.(PasswordHolder); ok {
+		return p.GetAuthPassword(), true
+	}
+	if p, ok := user.GetAuthData().(PasswordHolder); ok {
+		return p.GetAuthPassword(), true
+	}
+	return "", false
+}
+
+// HasVerifiedEmail reports if the user verified the email address. Users that
+// don't implement MustVerifyEmail are treated as verified.
+func HasVerifiedEmail(user Authenticable) bool {
+	if v, ok := user.(MustVerifyEmail); ok {
+		return v.HasVerifiedEmail()
+	}
+	if v, ok := user.GetAuthData().(MustVerifyEmail); ok {
+		return v.HasVerifiedEmail()
+	}
+	return true
+}
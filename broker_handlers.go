@@ -0,0 +1,204 @@
+package dojo
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const minPasswordLength = 8
+
+// defaultAuthViews are rendered when the application has no view with the same name
+//
+//go:embed views/auth/*.gohtml
+var defaultAuthViews embed.FS
+
+type brokerRequest struct {
+	Email                string `json:"email" form:"email"`
+	Token                string `json:"token" form:"token"`
+	Password             string `json:"password" form:"password"`
+	PasswordConfirmation string `json:"password_confirmation" form:"password_confirmation"`
+}
+
+// Routes registers the default handlers of the password reset and email
+// verification flows. Applications that need other paths or middlewares
+// register the handlers themselves.
+func (b *Broker) Routes(r *Router) {
+	r.GetWithName("/forgot-password", "password.request", b.ShowForgotPassword)
+	r.PostWithName("/forgot-password", "password.email", b.SendResetLink)
+	r.GetWithName(b.ResetPasswordPath, "password.reset", b.ShowResetPassword)
+	r.PostWithName(b.ResetPasswordPath, "password.update", b.Reset)
+	r.GetWithName(b.VerificationNoticePath, "verification.notice", b.ShowVerificationNotice)
+	r.GetWithName(b.VerifyEmailPath, "verification.verify", b.Verify)
+	r.PostWithName(b.VerificationSendPath, "verification.send", b.ResendVerification)
+}
+
+func (b *Broker) ShowForgotPassword(ctx Context) error {
+	return b.view(ctx, "auth/forgot-password", ViewAdditionalData{
+		"status": flashString(ctx, "status"),
+	})
+}
+
+func (b *Broker) SendResetLink(ctx Context) error {
+	in, err := bindBrokerRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if in.Email == "" {
+		return b.fail(ctx, http.StatusUnprocessableEntity, "the email is required", ctx.Request().URL.Path)
+	}
+	if err := b.SendPasswordResetLink(ctx, in.Email); err != nil {
+		return err
+	}
+	return b.done(ctx, "we have emailed your password reset link if the address has an account", ctx.Request().URL.Path)
+}
+
+func (b *Broker) ShowResetPassword(ctx Context) error {
+	return b.view(ctx, "auth/reset-password", ViewAdditionalData{
+		"token":  ctx.Request().URL.Query().Get("token"),
+		"action": ctx.Request().URL.RequestURI(),
+		"error":  flashString(ctx, "error"),
+	})
+}
+
+func (b *Broker) Reset(ctx Context) error {
+	in, err := bindBrokerRequest(ctx)
+	if err != nil {
+		return err
+	}
+	// The form posts to the page of the link, the token is kept when it came from the form
+	u := *ctx.Request().URL
+	if in.Token != "" {
+		query := u.Query()
+		query.Set("token", in.Token)
+		u.RawQuery = query.Encode()
+	}
+	back := u.RequestURI()
+
+	switch {
+	case len(in.Password) < minPasswordLength:
+		return b.fail(ctx, http.StatusUnprocessableEntity, fmt.Sprintf("the password must be at least %d characters", minPasswordLength), back)
+	case in.Password != in.PasswordConfirmation:
+		return b.fail(ctx, http.StatusUnprocessableEntity, "the password confirmation doesn't match", back)
+	}
+
+	err = b.ResetPassword(ctx, in.Token, in.Password)
+	if errors.Is(err, ErrBrokerTokenInvalid) {
+		return b.fail(ctx, http.StatusUnprocessableEntity, err.Error(), back)
+	}
+	if err != nil {
+		return err
+	}
+	return b.done(ctx, "your password has been reset", b.LoginPath)
+}
+
+func (b *Broker) ShowVerificationNotice(ctx Context) error {
+	user := b.auth.GetAuthUser(ctx)
+	if user.IsGuest() {
+		return ErrUnauthorized
+	}
+	if HasVerifiedEmail(&user) {
+		http.Redirect(ctx.Response(), ctx.Request(), b.HomePath, http.StatusFound)
+		return nil
+	}
+	return b.view(ctx, "auth/verify-email", ViewAdditionalData{
+		"status": flashString(ctx, "status"),
+		"action": b.VerificationSendPath,
+	})
+}
+
+// Verify handles the link of the verification mail. The session of the
+// verified user is refreshed, so the middlewares see the verified address.
+func (b *Broker) Verify(ctx Context) error {
+	verified, err := b.VerifyEmail(ctx, ctx.Request().URL.Query().Get("token"))
+	if errors.Is(err, ErrBrokerTokenInvalid) {
+		return NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	if user := b.auth.GetAuthUser(ctx); user.GetAuthIdentifier().Equal(AuthIdentifier(verified)) {
+		fresh, err := b.auth.Users.RetrieveByID(ctx, AuthIdentifier(verified).String())
+		if err != nil {
+			return err
+		}
+		if err := b.auth.Login(ctx, fresh); err != nil {
+			return err
+		}
+	}
+	return b.done(ctx, "your email address has been verified", b.HomePath)
+}
+
+func (b *Broker) ResendVerification(ctx Context) error {
+	user := b.auth.GetAuthUser(ctx)
+	if user.IsGuest() {
+		return ErrUnauthorized
+	}
+	if HasVerifiedEmail(&user) {
+		return b.done(ctx, "your email address is already verified", b.HomePath)
+	}
+	if err := b.SendVerificationLink(ctx, &user); err != nil {
+		return err
+	}
+	return b.done(ctx, "a new verification link has been sent to your email address", b.VerificationNoticePath)
+}
+
+// view renders the view of the application or the embedded default
+func (b *Broker) view(ctx Context, name string, data ViewAdditionalData) error {
+	d := b.auth.dojo
+	if _, err := os.Stat(filepath.Join(d.Configuration.View.Path, name+".gohtml")); err == nil {
+		return ctx.View(name, data)
+	}
+
+	ts, err := template.New(filepath.Base(name)+".gohtml").
+		Funcs(template.FuncMap{"csrf": csrfValue(ctx), "cspNonce": cspNonce(ctx)}).
+		ParseFS(defaultAuthViews, "views/"+name+".gohtml", "views/auth/layout.gohtml")
+	if err != nil {
+		return err
+	}
+	user := d.Auth.GetAuthUser(ctx)
+	ctx.Response().Header().Set(HeaderContentType, MIMETextHTMLCharsetUTF8)
+	return ts.Execute(ctx.Response(), ViewData{User: &user, Impersonating: d.Auth.IsImpersonating(ctx), Data: data})
+}
+
+// done answers a successful form post with a message or a redirect with a flash message
+func (b *Broker) done(ctx Context, message string, redirect string) error {
+	if ExpectsJSON(ctx) {
+		return ctx.JSON(http.StatusOK, map[string]string{"message": message})
+	}
+	ctx.Session().Flash("status", message)
+	http.Redirect(ctx.Response(), ctx.Request(), redirect, http.StatusFound)
+	return nil
+}
+
+// fail answers an invalid form post with an error or redirects back with a flash message
+func (b *Broker) fail(ctx Context, code int, message string, back string) error {
+	if ExpectsJSON(ctx) {
+		return NewHTTPError(code, message)
+	}
+	ctx.Session().Flash("error", message)
+	http.Redirect(ctx.Response(), ctx.Request(), back, http.StatusFound)
+	return nil
+}
+
+func bindBrokerRequest(ctx Context) (brokerRequest, error) {
+	var in brokerRequest
+	if err := ctx.Request().ParseForm(); err != nil {
+		return in, NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return in, ctx.Bind(&in)
+}
+
+func flashString(ctx Context, key string) string {
+	for _, f := range ctx.Session().GetFlash(key) {
+		if s, ok := f.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package dojo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newBrokerRoutesApp() (*Dojo, *testBrokerUser, *testMailer) {
+	app := newBrokerApp()
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	broker, user, mailer := newTestBroker(app)
+	broker.Routes(app.Route)
+	return app, user, mailer
+}
+
+func serveBroker(app *Dojo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	return rec
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set(HeaderContentType, MIMEApplicationForm)
+	return req
+}
+
+func TestBroker_ForgotPasswordView(t *testing.T) {
+	app, _, _ := newBrokerRoutesApp()
+
+	rec := serveBroker(app, httptest.NewRequest(http.MethodGet, "/forgot-password", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Forgot your password?") {
+		t.Fatalf("expected the default view, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get(HeaderContentType), MIMETextHTML) {
+		t.Errorf("expected html, got %q", rec.Header().Get(HeaderContentType))
+	}
+}
+
+func TestBroker_SendResetLink(t *testing.T) {
+	app, _, mailer := newBrokerRoutesApp()
+
+	rec := serveBroker(app, postForm("/forgot-password", url.Values{"email": {"john@example.com"}}))
+	if rec.Code != http.StatusFound || rec.Header().Get(HeaderLocation) != "/forgot-password" {
+		t.Fatalf("expected a redirect back, got %d %v", rec.Code, rec.Header())
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected one mail, got %d", len(mailer.sent))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/forgot-password", strings.NewReader(`{"email":"nobody@example.com"}`))
+	req.Header.Set(HeaderContentType, MIMEApplicationJSON)
+	req.Header.Set(HeaderAccept, MIMEApplicationJSON)
+	rec = serveBroker(app, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "if the address has an account") {
+		t.Errorf("expected the same answer for unknown addresses, got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(mailer.sent) != 1 {
+		t.Errorf("no mail should be sent to unknown addresses, got %d", len(mailer.sent))
+	}
+}
+
+func TestBroker_ResetThroughTheForm(t *testing.T) {
+	app, user, mailer := newBrokerRoutesApp()
+	if err := app.Auth.Broker.SendPasswordResetLink(context.Background(), "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	link := mailedLink(t, mailer)
+
+	rec := serveBroker(app, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Reset your password") {
+		t.Fatalf("expected the reset view, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveBroker(app, postForm(link.RequestURI(), url.Values{"password": {"short"}, "password_confirmation": {"short"}}))
+	if rec.Code != http.StatusFound || rec.Header().Get(HeaderLocation) != link.RequestURI() {
+		t.Fatalf("expected a redirect back for a short password, got %d %v", rec.Code, rec.Header())
+	}
+	if user.Password != "" {
+		t.Fatal("the password must not change for an invalid form")
+	}
+
+	form := url.Values{"password": {"new-secret-password"}, "password_confirmation": {"new-secret-password"}}
+	rec = serveBroker(app, postForm(link.RequestURI(), form))
+	if rec.Code != http.StatusFound || rec.Header().Get(HeaderLocation) != "/login" {
+		t.Fatalf("expected a redirect to the login, got %d %v", rec.Code, rec.Header())
+	}
+	if ok, _ := app.Auth.ComparePasswordAndHash("new-secret-password", user.Password); !ok {
+		t.Error("expected the new password to be stored")
+	}
+
+	req := postForm(link.RequestURI(), form)
+	req.Header.Set(HeaderAccept, MIMEApplicationJSON)
+	if rec = serveBroker(app, req); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a used link to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestBroker_ResetKeepsTheFormToken(t *testing.T) {
+	app, _, _ := newBrokerRoutesApp()
+
+	form := url.Values{"token": {"a&b=c"}, "password": {"short"}, "password_confirmation": {"short"}}
+	rec := serveBroker(app, postForm("/reset-password", form))
+	if rec.Code != http.StatusFound || rec.Header().Get(HeaderLocation) != "/reset-password?token=a%26b%3Dc" {
+		t.Errorf("expected a redirect back with the escaped token, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestBroker_VerifyLink(t *testing.T) {
+	app, user, mailer := newBrokerRoutesApp()
+	if err := app.Auth.Broker.SendVerificationLink(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	link := mailedLink(t, mailer)
+
+	rec := serveBroker(app, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	if rec.Code != http.StatusFound || rec.Header().Get(HeaderLocation) != "/" {
+		t.Fatalf("expected a redirect home, got %d %v", rec.Code, rec.Header())
+	}
+
+	tampered := strings.Replace(link.RequestURI(), "purpose=email_verification", "purpose=password_reset", 1)
+	if rec = serveBroker(app, httptest.NewRequest(http.MethodGet, tampered, nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a tampered link to be forbidden, got %d", rec.Code)
+	}
+}
+
+func TestBroker_VerificationNoticeNeedsAUser(t *testing.T) {
+	app, _, _ := newBrokerRoutesApp()
+
+	rec := serveBroker(app, httptest.NewRequest(http.MethodGet, "/email/verification-notice", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected guests to be unauthorized, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,263 @@
+package dojo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBrokerUser struct {
+	AuthUser
+	Email    string
+	Password string
+}
+
+func (u *testBrokerUser) GetEmail() string {
+	return u.Email
+}
+
+func (u *testBrokerUser) GetAuthPassword() string {
+	return u.Password
+}
+
+type testBrokerUsers struct {
+	user *testBrokerUser
+	err  error
+}
+
+func (p *testBrokerUsers) RetrieveByID(ctx context.Context, id string) (Authenticable, error) {
+	return p.user, nil
+}
+
+func (p *testBrokerUsers) RetrieveByEmail(ctx context.Context, email string) (Authenticable, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !strings.EqualFold(email, p.user.Email) {
+		return nil, nil
+	}
+	return p.user, nil
+}
+
+type testBrokerTokens struct {
+	tokens map[string]BrokerToken
+}
+
+func (s *testBrokerTokens) Create(ctx context.Context, token BrokerToken) error {
+	s.tokens[token.Hash] = token
+	return nil
+}
+
+func (s *testBrokerTokens) Find(ctx context.Context, hash string) (BrokerToken, error) {
+	token, ok := s.tokens[hash]
+	if !ok {
+		return token, ErrBrokerTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *testBrokerTokens) Delete(ctx context.Context, hash string) error {
+	delete(s.tokens, hash)
+	return nil
+}
+
+type testMailer struct {
+	sent []MailMessage
+}
+
+func (m *testMailer) Send(ctx context.Context, msg MailMessage) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newBrokerApp() *Dojo {
+	return New(DefaultConfiguration{
+		App:     AppConfig{Domain: "https://example.com"},
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+}
+
+func TestDojo_SignedURL(t *testing.T) {
+	app := newBrokerApp()
+
+	signed, err := app.SignedURL("/email/verify", url.Values{"user": {"42"}}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !app.HasValidSignature(httptest.NewRequest(http.MethodGet, signed, nil)) {
+		t.Errorf("expected %s to have a valid signature", signed)
+	}
+
+	tampered := strings.Replace(signed, "user=42", "user=43", 1)
+	if app.HasValidSignature(httptest.NewRequest(http.MethodGet, tampered, nil)) {
+		t.Error("expected a tampered url to be rejected")
+	}
+
+	expired, _ := app.SignedURL("/email/verify", url.Values{"user": {"42"}}, time.Now().Add(-time.Minute))
+	if app.HasValidSignature(httptest.NewRequest(http.MethodGet, expired, nil)) {
+		t.Error("expected an expired url to be rejected")
+	}
+}
+
+func TestDojo_SignedURL_NeedsSecret(t *testing.T) {
+	app := New(DefaultConfiguration{App: AppConfig{Domain: "https://example.com"}})
+
+	if _, err := app.SignedURL("/email/verify", url.Values{"user": {"42"}}, time.Now().Add(time.Minute)); err != ErrNoSigningSecret {
+		t.Errorf("expected ErrNoSigningSecret, got %v", err)
+	}
+
+	// A signature made with an empty key must not be accepted either
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte("/email/verify?expires=9999999999&user=42"))
+	forged := "/email/verify?expires=9999999999&user=42&signature=" + hex.EncodeToString(mac.Sum(nil))
+	if app.HasValidSignature(httptest.NewRequest(http.MethodGet, forged, nil)) {
+		t.Error("expected urls to be rejected without a secret")
+	}
+}
+
+// newTestBroker configures the broker of the app with a user, a mailer and
+// the callbacks. The token store is left to the test.
+func newTestBroker(app *Dojo) (*Broker, *testBrokerUser, *testMailer) {
+	user := &testBrokerUser{AuthUser: AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}, Email: "john@example.com"}
+	mailer := &testMailer{}
+
+	app.Auth.Users = &testBrokerUsers{user: user}
+	broker := app.Auth.Broker
+	broker.Mailer = mailer
+	broker.UpdatePassword = func(ctx context.Context, u Authenticable, hash string) error {
+		user.Password = hash
+		return nil
+	}
+	broker.MarkEmailVerified = func(ctx context.Context, u Authenticable) error {
+		return nil
+	}
+	return broker, user, mailer
+}
+
+// mailedLink returns the link of the last mail
+func mailedLink(t *testing.T, mailer *testMailer) *url.URL {
+	t.Helper()
+	if len(mailer.sent) == 0 {
+		t.Fatal("expected a mail")
+	}
+	text := mailer.sent[len(mailer.sent)-1].Text
+	start := strings.Index(text, "https://example.com/")
+	if start < 0 {
+		t.Fatalf("expected a link in %q", text)
+	}
+	link, err := url.Parse(strings.Fields(text[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestBroker_ResetPassword(t *testing.T) {
+	app := newBrokerApp()
+	broker, user, mailer := newTestBroker(app)
+	broker.Tokens = &testBrokerTokens{tokens: map[string]BrokerToken{}}
+
+	if err := broker.SendPasswordResetLink(context.Background(), "unknown@example.com"); err != nil {
+		t.Fatalf("unknown addresses must not return an error, got %v", err)
+	}
+	if len(mailer.sent) != 0 {
+		t.Fatal("no mail should be sent to unknown addresses")
+	}
+
+	if err := broker.SendPasswordResetLink(context.Background(), "John@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected one mail, got %d", len(mailer.sent))
+	}
+	if mailer.sent[0].To != "john@example.com" {
+		t.Errorf("expected the mail to go to the stored address, got %q", mailer.sent[0].To)
+	}
+	token := mailedLink(t, mailer).Query().Get("token")
+
+	ctx := app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/reset-password", nil))
+	if err := broker.ResetPassword(ctx, token, "new-secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := app.Auth.ComparePasswordAndHash("new-secret-password", user.Password); !ok {
+		t.Error("expected the new password hash to be stored")
+	}
+
+	if err := broker.ResetPassword(ctx, token, "another-password"); err != ErrBrokerTokenInvalid {
+		t.Errorf("expected a used token to be rejected, got %v", err)
+	}
+}
+
+func TestBroker_SendPasswordResetLinkErrors(t *testing.T) {
+	app := newBrokerApp()
+	broker, user, mailer := newTestBroker(app)
+
+	down := errors.New("database is down")
+	app.Auth.Users.(*testBrokerUsers).err = down
+	if err := broker.SendPasswordResetLink(context.Background(), "john@example.com"); err != down {
+		t.Errorf("expected the error of the provider, got %v", err)
+	}
+
+	// A user without address is answered like an unknown one
+	app.Auth.Users.(*testBrokerUsers).err = nil
+	user.Email = ""
+	if err := broker.SendPasswordResetLink(context.Background(), ""); err != nil || len(mailer.sent) != 0 {
+		t.Errorf("expected no error and no mail for a user without address, got %v %d", err, len(mailer.sent))
+	}
+}
+
+func TestBroker_RedeemChecksThePurposeFirst(t *testing.T) {
+	app := newBrokerApp()
+	broker, user, mailer := newTestBroker(app)
+	broker.Tokens = &testBrokerTokens{tokens: map[string]BrokerToken{}}
+
+	if err := broker.SendVerificationLink(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	token := mailedLink(t, mailer).Query().Get("token")
+
+	ctx := app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/reset-password", nil))
+	if err := broker.ResetPassword(ctx, token, "new-secret-password"); err != ErrBrokerTokenInvalid {
+		t.Fatalf("expected a verification token to be rejected by the reset, got %v", err)
+	}
+	if _, err := broker.VerifyEmail(ctx, token); err != nil {
+		t.Errorf("expected the verification token to stay usable, got %v", err)
+	}
+}
+
+func TestBroker_SignedResetLinkWorksOnce(t *testing.T) {
+	app := newBrokerApp()
+	broker, _, mailer := newTestBroker(app)
+
+	if err := broker.SendPasswordResetLink(context.Background(), "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	link := mailedLink(t, mailer)
+
+	ctx := app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, link.RequestURI(), nil))
+	if err := broker.ResetPassword(ctx, "", "new-secret-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.ResetPassword(ctx, "", "another-password"); err != ErrBrokerTokenInvalid {
+		t.Errorf("expected a used reset link to be rejected, got %v", err)
+	}
+}
+
+func TestBroker_SignedResetLinkNeedsThePasswordHash(t *testing.T) {
+	app := newBrokerApp()
+	broker, _, _ := newTestBroker(app)
+
+	_, err := broker.issue(context.Background(), &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}, PasswordResetPurpose, broker.ResetPasswordPath, time.Hour)
+	if err != ErrNoPasswordHash {
+		t.Errorf("expected ErrNoPasswordHash, got %v", err)
+	}
+}
@@ -77,6 +77,55 @@ type AuthenticationConfig struct {
 	ClientSecret string   `json:"clientSecret" yaml:"client_secret"`
 	Scopes       []string `json:"scopes" yaml:"scopes"`
 	RedirectPath string   `json:"redirectPath" yaml:"redirect_path"`
+	// The Configuration for the login throttle
+	Throttle LoginThrottleConfig `json:"throttle" yaml:"throttle"`
+	// The Configuration for the remember me cookie
+	Remember RememberConfig `json:"remember" yaml:"remember"`
+	// The Configuration for the two factor authentication
+	TwoFactor TwoFactorConfig `json:"twoFactor" yaml:"two_factor"`
+	// The Configuration for the password reset and email verification
+	Broker BrokerConfig `json:"broker" yaml:"broker"`
+	// Guards defines named guards next to the default "web" session guard
+	Guards map[string]GuardConfig `json:"guards" yaml:"guards"`
+}
+
+type BrokerConfig struct {
+	PasswordResetLifetime time.Duration `json:"passwordResetLifetime" yaml:"password_reset_lifetime"`
+	VerificationLifetime  time.Duration `json:"verificationLifetime" yaml:"verification_lifetime"`
+}
+
+type TwoFactorConfig struct {
+	Issuer string `json:"issuer" yaml:"issuer"`
+	// EncryptionKey encrypts the secrets at rest and keys the recovery code
+	// hashes, the session secret is used when it is empty
+	EncryptionKey string `json:"encryptionKey" yaml:"encryption_key"`
+	// Window is the number of time steps before and after the current one that
+	// are accepted, 0 only accepts the current step. 1 tolerates clock drift.
+	Window int `json:"window" yaml:"window"`
+}
+
+type RememberConfig struct {
+	CookieName string        `json:"cookieName" yaml:"cookie_name"`
+	Lifetime   time.Duration `json:"lifetime" yaml:"lifetime"`
+	// Grace is how long the previous token is accepted after a rotation, it defaults to 30 seconds
+	Grace time.Duration `json:"grace" yaml:"grace"`
+}
+
+type ThrottleStoreDriver string
+
+const (
+	MemoryThrottleStoreDriver   ThrottleStoreDriver = "memory"
+	RedisThrottleStoreDriver    ThrottleStoreDriver = "redis"
+	PostgresThrottleStoreDriver ThrottleStoreDriver = "postgres"
+)
+
+type LoginThrottleConfig struct {
+	Store ThrottleStoreDriver `json:"store" yaml:"store"`
+	// MaxAttempts are the failed attempts of a username, MaxAttemptsPerIP the ones of an ip
+	MaxAttempts      int           `json:"maxAttempts" yaml:"max_attempts"`
+	MaxAttemptsPerIP int           `json:"maxAttemptsPerIp" yaml:"max_attempts_per_ip"`
+	DecayWindow      time.Duration `json:"decayWindow" yaml:"decay_window"`
+	LockoutTime      time.Duration `json:"lockoutTime" yaml:"lockout_time"`
 }
 
 type RedisConfig struct {
@@ -86,6 +135,53 @@ type RedisConfig struct {
 	Port     int    `json:"port" yaml:"port"`
 }
 
+func (c RedisConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// SecureConfig are the security headers and the https redirect of the secure middleware
+type SecureConfig struct {
+	XContentTypeOptions string `json:"xContentTypeOptions" yaml:"x_content_type_options"`
+
+	XFrameOptions string `json:"xFrameOptions" yaml:"x_frame_options"`
+
+	// HSTSMaxAge in seconds, the Strict-Transport-Security header is only
+	// sent on https requests and when the max age is set.
+	HSTSMaxAge int `json:"hstsMaxAge" yaml:"hsts_max_age"`
+
+	HSTSIncludeSubdomains bool `json:"hstsIncludeSubdomains" yaml:"hsts_include_subdomains"`
+
+	HSTSPreload bool `json:"hstsPreload" yaml:"hsts_preload"`
+
+	ReferrerPolicy string `json:"referrerPolicy" yaml:"referrer_policy"`
+
+	PermissionsPolicy string `json:"permissionsPolicy" yaml:"permissions_policy"`
+
+	CrossOriginOpenerPolicy string `json:"crossOriginOpenerPolicy" yaml:"cross_origin_opener_policy"`
+
+	CrossOriginEmbedderPolicy string `json:"crossOriginEmbedderPolicy" yaml:"cross_origin_embedder_policy"`
+
+	// ContentSecurityPolicy is the policy of the responses, every "{nonce}" in it
+	// is replaced with a nonce that is generated per request, for example
+	// "script-src 'self' 'nonce-{nonce}'". The views read it with cspNonce.
+	ContentSecurityPolicy string `json:"contentSecurityPolicy" yaml:"content_security_policy"`
+
+	// CSPReportURI is added as report-uri directive to the policy
+	CSPReportURI string `json:"cspReportUri" yaml:"csp_report_uri"`
+
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only
+	CSPReportOnly bool `json:"cspReportOnly" yaml:"csp_report_only"`
+
+	// HTTPSRedirect redirects the http requests to https
+	HTTPSRedirect bool `json:"httpsRedirect" yaml:"https_redirect"`
+
+	// HTTPSHost is the host of the redirect, the host of the app domain is used when it is empty
+	HTTPSHost string `json:"httpsHost" yaml:"https_host"`
+
+	// TrustedProxies are the ips and cidr ranges whose X-Forwarded-Proto headers are trusted
+	TrustedProxies []string `json:"trustedProxies" yaml:"trusted_proxies"`
+}
+
 type DefaultConfiguration struct {
 	App     AppConfig            `json:"dojo" yaml:"dojo"`
 	DB      DatabaseConfig       `json:"db" yaml:"db"`
@@ -94,6 +190,7 @@ type DefaultConfiguration struct {
 	Session SessionConfig        `json:"session" yaml:"session"`
 	Auth    AuthenticationConfig `json:"auth" yaml:"auth"`
 	Redis   RedisConfig          `json:"redis" yaml:"redis"`
+	Secure  SecureConfig         `json:"secure" yaml:"secure"`
 }
 
 const defaultShutdownTimeoutSeconds = 15
@@ -142,6 +239,10 @@ func LoadConfigs(cfg *DefaultConfiguration) *DefaultConfiguration {
 			panic(errors.Wrap(err, "Cant unmarshall configuration"))
 		}
 
+		if err := cfg.Auth.Throttle.Validate(); err != nil {
+			panic(errors.Wrap(err, "Invalid configuration"))
+		}
+
 		instance = cfg
 	})
 
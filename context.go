@@ -2,13 +2,17 @@ package dojo
 
 import (
 	"context"
+	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Context interface {
 	context.Context
-	Response() http.ResponseWriter
+	Response() *Response
 	Request() *http.Request
+	SetRequest(r *http.Request)
 	Session() *Session
 	Cookies() *Cookies
 	Dojo() *Dojo
@@ -21,8 +25,26 @@ type Context interface {
 	NoContent(code int) error
 	View(view string, data ViewAdditionalData) error
 	RealIP() string
+	Authorize(ability string, args ...interface{}) error
+	Can(ability string, args ...interface{}) bool
+	TokenCan(ability string) bool
+	CSPNonce() string
+	Logger() *logrus.Entry
+	NotModifiedSince(t time.Time) bool
+	CacheControl(directives ...string)
 }
 
 type ParamValues interface {
 	Get(string) string
 }
+
+// ExpectsJSON reports if the client is an api or xhr client that can't follow
+// a redirect to a html page
+func ExpectsJSON(ctx Context) bool {
+	req := ctx.Request()
+	if strings.EqualFold(req.Header.Get(HeaderXRequestedWith), "XMLHttpRequest") {
+		return true
+	}
+	accept := req.Header.Get(HeaderAccept)
+	return strings.Contains(accept, MIMEApplicationJSON) || strings.Contains(accept, "+json")
+}
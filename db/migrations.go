@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+)
+
+// Migration is a named sql statement that is applied once to the database.
+type Migration struct {
+	Name string
+	Up   string
+	Down string
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS dojo_migrations (
+	name       text PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// Migrate applies the migrations that are not applied yet, each in its own transaction.
+func (d *Driver) Migrate(ctx context.Context, migrations ...Migration) error {
+	if _, err := d.Pool.Exec(ctx, createMigrationsTable); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		err := d.Pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+			var applied bool
+			err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM dojo_migrations WHERE name = $1)", m.Name).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, "INSERT INTO dojo_migrations (name) VALUES ($1)", m.Name)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rollback reverts the given migrations in reverse order.
+func (d *Driver) Rollback(ctx context.Context, migrations ...Migration) error {
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		err := d.Pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+			if m.Down != "" {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM dojo_migrations WHERE name = $1", m.Name)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/zengineDev/dojo"
+	"time"
+)
+
+var AccessTokenMigration = Migration{
+	Name: "create_personal_access_tokens_table",
+	Up: `CREATE TABLE personal_access_tokens (
+	id           uuid PRIMARY KEY,
+	user_id      text NOT NULL,
+	name         text NOT NULL,
+	token_hash   text NOT NULL,
+	abilities    text[] NOT NULL DEFAULT '{}',
+	last_used_at timestamptz,
+	expires_at   timestamptz,
+	created_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id)`,
+	Down: `DROP TABLE personal_access_tokens`,
+}
+
+var accessTokenColumns = []string{"id", "user_id", "name", "token_hash", "abilities", "last_used_at", "expires_at", "created_at"}
+
+// PostgresAccessTokenStore keeps the personal access tokens in the personal_access_tokens table.
+type PostgresAccessTokenStore struct {
+	PostgresStore
+}
+
+func NewPostgresAccessTokenStore() *PostgresAccessTokenStore {
+	s := &PostgresAccessTokenStore{}
+	s.Init()
+	return s
+}
+
+func (s *PostgresAccessTokenStore) Create(ctx context.Context, token dojo.PersonalAccessToken) error {
+	abilities := token.Abilities
+	if abilities == nil {
+		abilities = []string{}
+	}
+	sql, args, err := s.SB.Insert("personal_access_tokens").
+		Columns(accessTokenColumns...).
+		Values(token.ID.String(), token.UserID, token.Name, token.Hash, abilities, token.LastUsedAt, token.ExpiresAt, token.CreatedAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
+
+func (s *PostgresAccessTokenStore) Find(ctx context.Context, id uuid.UUID) (dojo.PersonalAccessToken, error) {
+	sql, args, err := s.SB.Select(accessTokenColumns...).
+		From("personal_access_tokens").
+		Where("id = ?", id.String()).
+		ToSql()
+	if err != nil {
+		return dojo.PersonalAccessToken{}, err
+	}
+
+	token, err := scanAccessToken(s.DB.Pool.QueryRow(ctx, sql, args...))
+	if err == pgx.ErrNoRows {
+		return token, dojo.ErrAccessTokenNotFound
+	}
+	return token, err
+}
+
+func (s *PostgresAccessTokenStore) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	sql, args, err := s.SB.Update("personal_access_tokens").
+		Set("last_used_at", at).
+		Where("id = ?", id.String()).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
+
+func (s *PostgresAccessTokenStore) Revoke(ctx context.Context, id uuid.UUID) error {
+	sql, args, err := s.SB.Delete("personal_access_tokens").Where("id = ?", id.String()).ToSql()
+	if err != nil {
+		return err
+	}
+	tag, err := s.DB.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return dojo.ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+func (s *PostgresAccessTokenStore) ForUser(ctx context.Context, userID string) ([]dojo.PersonalAccessToken, error) {
+	sql, args, err := s.SB.Select(accessTokenColumns...).
+		From("personal_access_tokens").
+		Where("user_id = ?", userID).
+		OrderBy("created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []dojo.PersonalAccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func scanAccessToken(row pgx.Row) (dojo.PersonalAccessToken, error) {
+	var token dojo.PersonalAccessToken
+	var id string
+	err := row.Scan(&id, &token.UserID, &token.Name, &token.Hash, &token.Abilities, &token.LastUsedAt, &token.ExpiresAt, &token.CreatedAt)
+	if err != nil {
+		return token, err
+	}
+	token.ID, err = uuid.FromString(id)
+	return token, err
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/zengineDev/dojo"
+)
+
+var BrokerTokenMigration = Migration{
+	Name: "create_broker_tokens_table",
+	Up: `CREATE TABLE broker_tokens (
+	hash       text PRIMARY KEY,
+	user_id    text NOT NULL,
+	purpose    text NOT NULL,
+	expires_at timestamptz NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX broker_tokens_user_id_purpose_idx ON broker_tokens (user_id, purpose)`,
+	Down: `DROP TABLE broker_tokens`,
+}
+
+// PostgresBrokerTokenStore keeps the password reset and email verification tokens in the broker_tokens table.
+type PostgresBrokerTokenStore struct {
+	PostgresStore
+}
+
+func NewPostgresBrokerTokenStore() *PostgresBrokerTokenStore {
+	s := &PostgresBrokerTokenStore{}
+	s.Init()
+	return s
+}
+
+func (s *PostgresBrokerTokenStore) Create(ctx context.Context, token dojo.BrokerToken) error {
+	return s.DB.Pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		sql, args, err := s.SB.Delete("broker_tokens").
+			Where("user_id = ? AND purpose = ?", token.UserID, string(token.Purpose)).
+			ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+
+		sql, args, err = s.SB.Insert("broker_tokens").
+			Columns("hash", "user_id", "purpose", "expires_at").
+			Values(token.Hash, token.UserID, string(token.Purpose), token.ExpiresAt).
+			ToSql()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+}
+
+func (s *PostgresBrokerTokenStore) Find(ctx context.Context, hash string) (dojo.BrokerToken, error) {
+	var token dojo.BrokerToken
+	var purpose string
+	sql, args, err := s.SB.Select("hash", "user_id", "purpose", "expires_at").
+		From("broker_tokens").
+		Where("hash = ?", hash).
+		ToSql()
+	if err != nil {
+		return token, err
+	}
+
+	err = s.DB.Pool.QueryRow(ctx, sql, args...).Scan(&token.Hash, &token.UserID, &purpose, &token.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		return token, dojo.ErrBrokerTokenNotFound
+	}
+	token.Purpose = dojo.TokenPurpose(purpose)
+	return token, err
+}
+
+func (s *PostgresBrokerTokenStore) Delete(ctx context.Context, hash string) error {
+	sql, args, err := s.SB.Delete("broker_tokens").Where("hash = ?", hash).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
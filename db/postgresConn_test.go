@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"os"
+	"testing"
+)
+
+// testStore connects to the database of DOJO_TEST_POSTGRES_DSN and applies
+// the migrations, they are rolled back after the test. The test is skipped
+// without a database.
+func testStore(t *testing.T, migrations ...Migration) PostgresStore {
+	t.Helper()
+	dsn := os.Getenv("DOJO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("DOJO_TEST_POSTGRES_DSN is not set")
+	}
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := &Driver{Pool: pool}
+	if err := driver.Migrate(context.Background(), migrations...); err != nil {
+		pool.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := driver.Rollback(context.Background(), migrations...); err != nil {
+			t.Error(err)
+		}
+		pool.Close()
+	})
+
+	return PostgresStore{SB: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar), DB: driver}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/zengineDev/dojo"
+	"time"
+)
+
+var RememberTokenMigration = Migration{
+	Name: "create_remember_tokens_table",
+	Up: `CREATE TABLE remember_tokens (
+	selector      text PRIMARY KEY,
+	hash          text NOT NULL,
+	previous_hash text NOT NULL DEFAULT '',
+	user_id       text NOT NULL,
+	expires_at    timestamptz NOT NULL,
+	rotated_at    timestamptz,
+	created_at    timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX remember_tokens_user_id_idx ON remember_tokens (user_id)`,
+	Down: `DROP TABLE remember_tokens`,
+}
+
+// PostgresRememberTokenStore keeps the remember me tokens in the remember_tokens table.
+type PostgresRememberTokenStore struct {
+	PostgresStore
+}
+
+func NewPostgresRememberTokenStore() *PostgresRememberTokenStore {
+	s := &PostgresRememberTokenStore{}
+	s.Init()
+	return s
+}
+
+func (s *PostgresRememberTokenStore) Create(ctx context.Context, token dojo.RememberToken) error {
+	sql, args, err := s.SB.Insert("remember_tokens").
+		Columns("selector", "hash", "user_id", "expires_at").
+		Values(token.Selector, token.Hash, token.UserID, token.ExpiresAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
+
+func (s *PostgresRememberTokenStore) Find(ctx context.Context, selector string) (dojo.RememberToken, error) {
+	var token dojo.RememberToken
+	var rotatedAt *time.Time
+	sql, args, err := s.SB.Select("selector", "hash", "previous_hash", "user_id", "expires_at", "rotated_at").
+		From("remember_tokens").
+		Where("selector = ?", selector).
+		ToSql()
+	if err != nil {
+		return token, err
+	}
+
+	err = s.DB.Pool.QueryRow(ctx, sql, args...).
+		Scan(&token.Selector, &token.Hash, &token.PreviousHash, &token.UserID, &token.ExpiresAt, &rotatedAt)
+	if err == pgx.ErrNoRows {
+		return token, dojo.ErrRememberTokenNotFound
+	}
+	if rotatedAt != nil {
+		token.RotatedAt = *rotatedAt
+	}
+	return token, err
+}
+
+// Update rotates the token when its hash is still oldHash, so only one of
+// concurrent rotations wins.
+func (s *PostgresRememberTokenStore) Update(ctx context.Context, token dojo.RememberToken, oldHash string) error {
+	sql, args, err := s.SB.Update("remember_tokens").
+		Set("hash", token.Hash).
+		Set("previous_hash", token.PreviousHash).
+		Set("expires_at", token.ExpiresAt).
+		Set("rotated_at", token.RotatedAt).
+		Where("selector = ? AND hash = ?", token.Selector, oldHash).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	tag, err := s.DB.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return dojo.ErrRememberTokenConflict
+	}
+	return nil
+}
+
+func (s *PostgresRememberTokenStore) Delete(ctx context.Context, selector string) error {
+	sql, args, err := s.SB.Delete("remember_tokens").Where("selector = ?", selector).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
+
+func (s *PostgresRememberTokenStore) DeleteForUser(ctx context.Context, userID string) error {
+	sql, args, err := s.SB.Delete("remember_tokens").Where("user_id = ?", userID).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
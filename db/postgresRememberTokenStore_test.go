@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"github.com/zengineDev/dojo"
+	"testing"
+	"time"
+)
+
+func TestPostgresRememberTokenStore_UpdateIsACompareAndSwap(t *testing.T) {
+	s := &PostgresRememberTokenStore{PostgresStore: testStore(t, RememberTokenMigration)}
+	ctx := context.Background()
+
+	token := dojo.RememberToken{Selector: "s1", Hash: "h1", UserID: "42", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := token
+	rotated.PreviousHash, rotated.Hash, rotated.RotatedAt = "h1", "h2", time.Now()
+	if err := s.Update(ctx, rotated, "h1"); err != nil {
+		t.Fatal(err)
+	}
+
+	lost := token
+	lost.PreviousHash, lost.Hash, lost.RotatedAt = "h1", "h3", time.Now()
+	if err := s.Update(ctx, lost, "h1"); err != dojo.ErrRememberTokenConflict {
+		t.Fatalf("expected the second rotation to conflict, got %v", err)
+	}
+
+	found, err := s.Find(ctx, "s1")
+	if err != nil || found.Hash != "h2" || found.PreviousHash != "h1" {
+		t.Errorf("expected the first rotation to be kept, got %+v %v", found, err)
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+)
+
+var RoleMigration = Migration{
+	Name: "create_roles_and_permissions_tables",
+	Up: `CREATE TABLE roles (
+	id         bigserial PRIMARY KEY,
+	name       text NOT NULL UNIQUE,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE TABLE permissions (
+	id         bigserial PRIMARY KEY,
+	name       text NOT NULL UNIQUE,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE TABLE role_permissions (
+	role_id       bigint NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+	permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+CREATE TABLE user_roles (
+	user_id text NOT NULL,
+	role_id bigint NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+CREATE TABLE user_permissions (
+	user_id       text NOT NULL,
+	permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, permission_id)
+)`,
+	Down: `DROP TABLE user_permissions, user_roles, role_permissions, permissions, roles`,
+}
+
+// PostgresRoleStore keeps the roles and permissions of the users, it implements dojo.RoleProvider.
+type PostgresRoleStore struct {
+	PostgresStore
+}
+
+func NewPostgresRoleStore() *PostgresRoleStore {
+	s := &PostgresRoleStore{}
+	s.Init()
+	return s
+}
+
+func (s *PostgresRoleStore) RolesFor(ctx context.Context, userID string) ([]string, error) {
+	sql, args, err := s.SB.Select("r.name").
+		From("roles r").
+		Join("user_roles ur ON ur.role_id = r.id").
+		Where("ur.user_id = ?", userID).
+		OrderBy("r.name").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return s.names(ctx, sql, args...)
+}
+
+func (s *PostgresRoleStore) PermissionsFor(ctx context.Context, userID string) ([]string, error) {
+	return s.names(ctx, `SELECT p.name FROM permissions p
+	JOIN role_permissions rp ON rp.permission_id = p.id
+	JOIN user_roles ur ON ur.role_id = rp.role_id
+	WHERE ur.user_id = $1
+UNION
+SELECT p.name FROM permissions p
+	JOIN user_permissions up ON up.permission_id = p.id
+	WHERE up.user_id = $1`, userID)
+}
+
+func (s *PostgresRoleStore) CreateRole(ctx context.Context, name string) error {
+	_, err := s.DB.Pool.Exec(ctx, "INSERT INTO roles (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name)
+	return err
+}
+
+func (s *PostgresRoleStore) CreatePermission(ctx context.Context, name string) error {
+	_, err := s.DB.Pool.Exec(ctx, "INSERT INTO permissions (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name)
+	return err
+}
+
+// GrantPermission grants the permission to the role, both are created when missing.
+func (s *PostgresRoleStore) GrantPermission(ctx context.Context, role string, permission string) error {
+	if err := s.CreateRole(ctx, role); err != nil {
+		return err
+	}
+	if err := s.CreatePermission(ctx, permission); err != nil {
+		return err
+	}
+	_, err := s.DB.Pool.Exec(ctx, `INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = $1 AND p.name = $2
+ON CONFLICT DO NOTHING`, role, permission)
+	return err
+}
+
+func (s *PostgresRoleStore) RevokePermission(ctx context.Context, role string, permission string) error {
+	_, err := s.DB.Pool.Exec(ctx, `DELETE FROM role_permissions
+WHERE role_id = (SELECT id FROM roles WHERE name = $1)
+AND permission_id = (SELECT id FROM permissions WHERE name = $2)`, role, permission)
+	return err
+}
+
+// AssignRole assigns the role to the user, the role is created when missing.
+func (s *PostgresRoleStore) AssignRole(ctx context.Context, userID string, role string) error {
+	if err := s.CreateRole(ctx, role); err != nil {
+		return err
+	}
+	_, err := s.DB.Pool.Exec(ctx, `INSERT INTO user_roles (user_id, role_id)
+SELECT $1, id FROM roles WHERE name = $2
+ON CONFLICT DO NOTHING`, userID, role)
+	return err
+}
+
+func (s *PostgresRoleStore) RemoveRole(ctx context.Context, userID string, role string) error {
+	_, err := s.DB.Pool.Exec(ctx, `DELETE FROM user_roles
+WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`, userID, role)
+	return err
+}
+
+// GivePermission grants the permission directly to the user, the permission is created when missing.
+func (s *PostgresRoleStore) GivePermission(ctx context.Context, userID string, permission string) error {
+	if err := s.CreatePermission(ctx, permission); err != nil {
+		return err
+	}
+	_, err := s.DB.Pool.Exec(ctx, `INSERT INTO user_permissions (user_id, permission_id)
+SELECT $1, id FROM permissions WHERE name = $2
+ON CONFLICT DO NOTHING`, userID, permission)
+	return err
+}
+
+func (s *PostgresRoleStore) names(ctx context.Context, sql string, args ...interface{}) ([]string, error) {
+	rows, err := s.DB.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPostgresRoleStore(t *testing.T) {
+	s := &PostgresRoleStore{PostgresStore: testStore(t, RoleMigration)}
+	ctx := context.Background()
+
+	steps := []error{
+		s.GrantPermission(ctx, "editor", "posts.*"),
+		s.GrantPermission(ctx, "editor", "comments.delete"),
+		s.AssignRole(ctx, "42", "editor"),
+		s.AssignRole(ctx, "42", "author"),
+		s.GivePermission(ctx, "42", "users.view"),
+		s.GivePermission(ctx, "42", "posts.*"),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roles, err := s.RolesFor(ctx, "42")
+	if err != nil || !reflect.DeepEqual(roles, []string{"author", "editor"}) {
+		t.Fatalf("expected the sorted roles, got %v %v", roles, err)
+	}
+	permissions, err := s.PermissionsFor(ctx, "42")
+	sort.Strings(permissions)
+	if err != nil || !reflect.DeepEqual(permissions, []string{"comments.delete", "posts.*", "users.view"}) {
+		t.Fatalf("expected the permissions of the roles and the user once, got %v %v", permissions, err)
+	}
+
+	if err := s.RevokePermission(ctx, "editor", "comments.delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveRole(ctx, "42", "author"); err != nil {
+		t.Fatal(err)
+	}
+	roles, _ = s.RolesFor(ctx, "42")
+	permissions, _ = s.PermissionsFor(ctx, "42")
+	sort.Strings(permissions)
+	if !reflect.DeepEqual(roles, []string{"editor"}) || !reflect.DeepEqual(permissions, []string{"posts.*", "users.view"}) {
+		t.Errorf("expected the revoked role and permission to be gone, got %v %v", roles, permissions)
+	}
+
+	if roles, err := s.RolesFor(ctx, "43"); err != nil || len(roles) != 0 {
+		t.Errorf("expected no roles for another user, got %v %v", roles, err)
+	}
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/zengineDev/dojo"
+	"time"
+)
+
+var ThrottleMigration = Migration{
+	Name: "create_login_throttles_table",
+	Up: `CREATE TABLE login_throttles (
+	key          text PRIMARY KEY,
+	attempts     integer NOT NULL DEFAULT 0,
+	expires_at   timestamptz NOT NULL,
+	locked_until timestamptz
+)`,
+	Down: `DROP TABLE login_throttles`,
+}
+
+// PostgresThrottleStore keeps the login attempts in the login_throttles table.
+type PostgresThrottleStore struct {
+	PostgresStore
+}
+
+func init() {
+	dojo.RegisterThrottleStoreDriver(dojo.PostgresThrottleStoreDriver, func(*dojo.Dojo) dojo.ThrottleStore {
+		return NewPostgresThrottleStore()
+	})
+}
+
+func NewPostgresThrottleStore() *PostgresThrottleStore {
+	s := &PostgresThrottleStore{}
+	s.Init()
+	return s
+}
+
+func (s *PostgresThrottleStore) Hit(ctx context.Context, key string, decay time.Duration) (int, error) {
+	var attempts int
+	err := s.DB.Pool.QueryRow(ctx, `INSERT INTO login_throttles (key, attempts, expires_at) VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE SET
+	attempts = CASE WHEN login_throttles.expires_at < now() THEN 1 ELSE login_throttles.attempts + 1 END,
+	expires_at = CASE WHEN login_throttles.expires_at < now() THEN EXCLUDED.expires_at ELSE login_throttles.expires_at END
+RETURNING attempts`, key, time.Now().Add(decay)).Scan(&attempts)
+	return attempts, err
+}
+
+func (s *PostgresThrottleStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.DB.Pool.Exec(ctx, `INSERT INTO login_throttles (key, expires_at, locked_until) VALUES ($1, now(), $2)
+ON CONFLICT (key) DO UPDATE SET locked_until = EXCLUDED.locked_until`, key, until)
+	return err
+}
+
+func (s *PostgresThrottleStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	sql, args, err := s.SB.Select("locked_until").From("login_throttles").Where("key = ?", key).ToSql()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var until *time.Time
+	err = s.DB.Pool.QueryRow(ctx, sql, args...).Scan(&until)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil || until == nil {
+		return time.Time{}, err
+	}
+	return *until, nil
+}
+
+func (s *PostgresThrottleStore) Clear(ctx context.Context, key string) error {
+	sql, args, err := s.SB.Delete("login_throttles").Where("key = ?", key).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Pool.Exec(ctx, sql, args...)
+	return err
+}
@@ -7,17 +7,21 @@ import (
 	"fmt"
 	"github.com/Masterminds/formenc/encoding/form"
 	"github.com/golang/gddo/httputil/header"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
 	"github.com/tomasen/realip"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
 type DefaultContext struct {
 	context.Context
-	response http.ResponseWriter
+	response *Response
 	request  *http.Request
 	params   url.Values
 	session  *Session
@@ -29,8 +33,8 @@ func (ctx *DefaultContext) Dojo() *Dojo {
 	return ctx.dojo
 }
 
-// Response returns the original Response for the request.
-func (ctx *DefaultContext) Response() http.ResponseWriter {
+// Response returns the Response of the request, it wraps the original writer.
+func (ctx *DefaultContext) Response() *Response {
 	return ctx.response
 }
 
@@ -39,6 +43,44 @@ func (ctx *DefaultContext) Request() *http.Request {
 	return ctx.request
 }
 
+// SetRequest replaces the request, the context of the request becomes the
+// context of ctx, so its deadline and cancellation apply to ctx.
+func (ctx *DefaultContext) SetRequest(r *http.Request) {
+	ctx.request = r
+	ctx.Context = r.Context()
+}
+
+// ContextWithResponse returns a copy of ctx that writes to w, it shares the data
+// with ctx. The session is copied, the copy can be changed and saved to w
+// without a race with ctx. Use it to run a handler in another goroutine. It
+// panics when ctx is not a *DefaultContext, it can't be copied.
+func ContextWithResponse(ctx Context, w http.ResponseWriter) Context {
+	c, ok := ctx.(*DefaultContext)
+	if !ok {
+		panic(fmt.Sprintf("dojo: ContextWithResponse needs a *DefaultContext, got %T", ctx))
+	}
+	clone := *c
+	clone.response = NewResponse(w)
+	if c.session != nil && c.session.Session != nil {
+		clone.session = &Session{Session: copySession(c.dojo.SessionStore, c.session.Session), req: c.session.req, res: clone.response}
+	}
+	return &clone
+}
+
+func copySession(store sessions.Store, s *sessions.Session) *sessions.Session {
+	clone := sessions.NewSession(store, s.Name())
+	clone.ID = s.ID
+	clone.IsNew = s.IsNew
+	if s.Options != nil {
+		options := *s.Options
+		clone.Options = &options
+	}
+	for k, v := range s.Values {
+		clone.Values[k] = v
+	}
+	return clone
+}
+
 func (ctx *DefaultContext) Params() ParamValues {
 	return ctx.params
 }
@@ -74,6 +116,106 @@ func (ctx *DefaultContext) RealIP() string {
 	return realip.FromRequest(ctx.Request())
 }
 
+// CSPNonce returns the content security policy nonce of the request, it is
+// empty when the secure middleware didn't generate one.
+func (ctx *DefaultContext) CSPNonce() string {
+	nonce, _ := ctx.Value(CSPNonceKey).(string)
+	return nonce
+}
+
+// forRoute prepares the context of the global middlewares for the matched route
+func (ctx *DefaultContext) forRoute(rc RouteConfig, r *http.Request) {
+	ctx.SetRequest(r)
+	params := url.Values{}
+	for k, v := range mux.Vars(r) {
+		params.Add(k, v)
+	}
+	for k, v := range ctx.params {
+		params[k] = append(params[k], v...)
+	}
+	ctx.params = params
+	ctx.data.Store("current_route", rc)
+}
+
+// Logger returns an entry of the application logger with the request id,
+// route, user and method of the request.
+func (ctx *DefaultContext) Logger() *logrus.Entry {
+	fields := logrus.Fields{
+		"request_id": ctx.requestID(),
+		"method":     ctx.request.Method,
+	}
+	if route := mux.CurrentRoute(ctx.request); route != nil && route.GetName() != "" {
+		fields["route"] = route.GetName()
+	}
+	if ctx.dojo.Auth != nil {
+		if user := ctx.dojo.Auth.GetAuthUser(ctx); !user.IsGuest() {
+			fields["user_id"] = user.GetAuthIdentifier().String()
+		}
+	}
+	return ctx.dojo.Logger.WithFields(fields)
+}
+
+// requestID returns the id the request id middleware assigned to the request
+func (ctx *DefaultContext) requestID() string {
+	if id, ok := ctx.Value(RequestIDKey).(string); ok {
+		return id
+	}
+	return ctx.response.Header().Get(HeaderXRequestID)
+}
+
+// NotModifiedSince sets the Last-Modified header and reports if the client
+// has the response of t already, the handler answers with 304 then:
+//
+//	if ctx.NotModifiedSince(post.UpdatedAt) {
+//		return ctx.NoContent(http.StatusNotModified)
+//	}
+func (ctx *DefaultContext) NotModifiedSince(t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	t = t.UTC().Truncate(time.Second)
+	ctx.response.Header().Set(HeaderLastModified, t.Format(http.TimeFormat))
+
+	req := ctx.request
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	// If-None-Match takes precedence over If-Modified-Since
+	if req.Header.Get(HeaderIfNoneMatch) != "" {
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get(HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	return !t.After(since)
+}
+
+// CacheControl sets the Cache-Control header, for example
+// ctx.CacheControl("public", "max-age=3600").
+func (ctx *DefaultContext) CacheControl(directives ...string) {
+	ctx.response.Header().Set(HeaderCacheControl, strings.Join(directives, ", "))
+}
+
+// Authorize returns ErrForbidden when the authenticated user is not allowed to perform the ability
+func (ctx *DefaultContext) Authorize(ability string, args ...interface{}) error {
+	user := ctx.dojo.Auth.GetAuthUser(ctx)
+	return ctx.dojo.Gate.Authorize(&user, ability, args...)
+}
+
+// Can reports if the authenticated user is allowed to perform the ability
+func (ctx *DefaultContext) Can(ability string, args ...interface{}) bool {
+	user := ctx.dojo.Auth.GetAuthUser(ctx)
+	return ctx.dojo.Gate.Allows(&user, ability, args...)
+}
+
+// TokenCan reports if the access token of the request has the ability, it is
+// false when the request was not authenticated with an access token.
+func (ctx *DefaultContext) TokenCan(ability string) bool {
+	token, ok := ctx.dojo.Auth.CurrentAccessToken(ctx)
+	return ok && token.Can(ability)
+}
+
 func (ctx *DefaultContext) Bind(dst interface{}) error {
 	if ctx.Request().Header.Get("Content-Type") != "" {
 		value, _ := header.ParseValueAndParams(ctx.Request().Header, "Content-Type")
@@ -187,10 +329,6 @@ type JsonResponseBody struct {
 
 func (ctx *DefaultContext) NoContent(code int) error {
 	ctx.response.WriteHeader(code)
-	_, err := ctx.Response().Write(nil)
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/sirupsen/logrus"
@@ -24,8 +25,14 @@ type (
 		SessionStore       *sessions.CookieStore
 		HTTPErrorHandler   HTTPErrorHandler
 		Auth               *Authentication
+		Gate               *Gate
 		Route              *Router
 		Debug              bool
+
+		globalMiddlewares MiddlewareStack
+
+		redis     *redis.Client
+		redisOnce sync.Once
 	}
 
 	HTTPErrorHandler func(error, Context)
@@ -76,8 +83,15 @@ const (
 	HeaderCookie              = "Cookie"
 	HeaderSetCookie           = "Set-Cookie"
 	HeaderIfModifiedSince     = "If-Modified-Since"
+	HeaderIfNoneMatch         = "If-None-Match"
+	HeaderETag                = "ETag"
+	HeaderCacheControl        = "Cache-Control"
 	HeaderLastModified        = "Last-Modified"
 	HeaderLocation            = "Location"
+	HeaderRetryAfter          = "Retry-After"
+	HeaderRateLimitLimit      = "RateLimit-Limit"
+	HeaderRateLimitRemaining  = "RateLimit-Remaining"
+	HeaderRateLimitReset      = "RateLimit-Reset"
 	HeaderUpgrade             = "Upgrade"
 	HeaderVary                = "Vary"
 	HeaderWWWAuthenticate     = "WWW-Authenticate"
@@ -112,10 +126,19 @@ const (
 	HeaderContentSecurityPolicyReportOnly = "Content-Security-Policy-Report-Only"
 	HeaderXCSRFToken                      = "X-CSRF-Token"
 	HeaderReferrerPolicy                  = "Referrer-Policy"
+	HeaderPermissionsPolicy               = "Permissions-Policy"
+	HeaderCrossOriginOpenerPolicy         = "Cross-Origin-Opener-Policy"
+	HeaderCrossOriginEmbedderPolicy       = "Cross-Origin-Embedder-Policy"
 )
 
 const FlashOldKey = "_old_inputs"
 
+// CSPNonceKey is the context key of the content security policy nonce
+const CSPNonceKey = "csp_nonce"
+
+// RequestIDKey is the context key of the request id
+const RequestIDKey = "request_id"
+
 // HTTPError represents an error that occurred while handling a request.
 type HTTPError struct {
 	Code     int         `json:"-"`
@@ -127,6 +150,11 @@ func (e *HTTPError) Error() string {
 	return fmt.Sprintf("%s", e.Message)
 }
 
+// Unwrap returns the internal error, so errors.Is and errors.As can find it
+func (e *HTTPError) Unwrap() error {
+	return e.Internal
+}
+
 var (
 	ErrUnsupportedMediaType        = NewHTTPError(http.StatusUnsupportedMediaType)
 	ErrNotFound                    = NewHTTPError(http.StatusNotFound)
@@ -179,8 +207,10 @@ func New(conf DefaultConfiguration) *Dojo {
 		Logger:             logger,
 		SessionStore:       cookieStore,
 		Debug:              conf.App.Debug,
+		Gate:               NewGate(),
 	}
 
+	d.HTTPErrorHandler = d.DefaultHTTPErrorHandler
 	d.Auth = NewAuthentication(d)
 	d.Route = NewRouter(d)
 
@@ -205,7 +235,8 @@ func (dojo *Dojo) NewContext(rc RouteConfig, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	session := dojo.getSession(r, w)
+	res := NewResponse(w)
+	session := dojo.getSession(r, res)
 
 	data := &sync.Map{}
 
@@ -221,7 +252,7 @@ func (dojo *Dojo) NewContext(rc RouteConfig, w http.ResponseWriter, r *http.Requ
 		Context: r.Context(),
 		// contentType: ct,
 		session:  session,
-		response: w,
+		response: res,
 		request:  r,
 		params:   params,
 		data:     data,
@@ -239,6 +270,10 @@ func (dojo *Dojo) getSession(r *http.Request, w http.ResponseWriter) *Session {
 }
 
 func (dojo *Dojo) DefaultHTTPErrorHandler(err error, c Context) {
+	if c.Response().Committed {
+		return
+	}
+
 	he, ok := err.(*HTTPError)
 	if ok {
 		if he.Internal != nil {
@@ -277,7 +312,7 @@ func (dojo *Dojo) DefaultHTTPErrorHandler(err error, c Context) {
 func (dojo *Dojo) Serve() {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", dojo.Configuration.App.Port),
-		Handler:      dojo.Route.GetMux(),
+		Handler:      dojo.Handler(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/spf13/cobra"
+	"github.com/zengineDev/dojo"
+	"github.com/zengineDev/dojo/db"
+	"log"
+	"time"
+)
+
+var (
+	tokenAbilities []string
+	tokenExpiresIn time.Duration
+)
+
+func init() {
+	tokenIssueCmd.Flags().StringSliceVar(&tokenAbilities, "abilities", nil, `the abilities of the token, "*" grants all of them`)
+	tokenIssueCmd.Flags().DurationVar(&tokenExpiresIn, "expires-in", 0, "the lifetime of the token, it never expires when not set")
+	// A token with all abilities has to be asked for explicitly
+	_ = tokenIssueCmd.MarkFlagRequired("abilities")
+	tokenCmd.AddCommand(tokenIssueCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage the personal access tokens",
+}
+
+var tokenIssueCmd = &cobra.Command{
+	Use:   "issue [user-id] [name]",
+	Short: "Issue a new personal access token for a user",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var expiresAt *time.Time
+		if tokenExpiresIn > 0 {
+			t := time.Now().Add(tokenExpiresIn)
+			expiresAt = &t
+		}
+
+		plain, token, err := dojo.NewPersonalAccessToken(args[0], args[1], tokenAbilities, expiresAt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.NewPostgresAccessTokenStore().Create(context.Background(), token); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Token %s issued, it is only shown once:\n%s\n", token.ID, plain)
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke [token-id]",
+	Short: "Revoke a personal access token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := uuid.FromString(args[0])
+		if err != nil {
+			log.Fatalf("invalid token id: %v", err)
+		}
+		if err := db.NewPostgresAccessTokenStore().Revoke(context.Background(), id); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Token %s revoked\n", id)
+	},
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_tokenIssueRequiresAbilities(t *testing.T) {
+	rootCmd.SetArgs([]string{"token", "issue", "42", "ci"})
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetArgs(nil)
+
+	err := rootCmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), `"abilities" not set`) {
+		t.Errorf("expected the abilities to be required, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package dojo
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AcceptedEncodings returns the supported content encodings the Accept-Encoding
+// header allows, the best first. Encodings with the same q-value keep the
+// order of supported.
+func AcceptedEncodings(header string, supported ...string) []string {
+	q := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		value := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				value = v
+			}
+		}
+		if name == "*" {
+			wildcard = value
+			continue
+		}
+		q[name] = value
+	}
+
+	var accepted []string
+	weight := make(map[string]float64)
+	for _, enc := range supported {
+		v, ok := q[enc]
+		if !ok {
+			v = wildcard
+		}
+		if v > 0 {
+			accepted = append(accepted, enc)
+			weight[enc] = v
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return weight[accepted[i]] > weight[accepted[j]]
+	})
+	return accepted
+}
+
+// precompressedExtensions are the siblings the assets file server looks for
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// precompressedFileServer serves the .br or .gz sibling of a file when the
+// client accepts the encoding and the sibling exists. The file itself has to
+// exist, a sibling left behind by a deleted file is not served.
+func precompressedFileServer(root http.FileSystem) http.Handler {
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		w.Header().Add(HeaderVary, HeaderAcceptEncoding)
+
+		if !isFile(root, name) {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		for _, enc := range AcceptedEncodings(r.Header.Get(HeaderAcceptEncoding), "br", "gzip") {
+			f, err := root.Open(name + precompressedExtensions[enc])
+			if err != nil {
+				continue
+			}
+			info, err := f.Stat()
+			if err != nil || info.IsDir() {
+				f.Close()
+				continue
+			}
+
+			ct := mime.TypeByExtension(path.Ext(name))
+			if ct == "" {
+				ct = "application/octet-stream"
+			}
+			w.Header().Set(HeaderContentType, ct)
+			w.Header().Set(HeaderContentEncoding, enc)
+			http.ServeContent(w, r, name, info.ModTime(), f)
+			f.Close()
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+func isFile(root http.FileSystem, name string) bool {
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && !info.IsDir()
+}
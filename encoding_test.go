@@ -0,0 +1,67 @@
+package dojo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAcceptedEncodings(t *testing.T) {
+	cases := map[string][]string{
+		"gzip, br":             {"br", "gzip"},
+		"gzip;q=0.9, br;q=0.1": {"gzip", "br"},
+		"*;q=0.5, gzip":        {"gzip", "br"},
+		"br;q=0, x-gzip":       {"gzip"},
+		"identity":             nil,
+		"":                     nil,
+	}
+	for header, expect := range cases {
+		if got := AcceptedEncodings(header, "br", "gzip"); !reflect.DeepEqual(got, expect) {
+			t.Errorf("%q: expected %v, got %v", header, expect, got)
+		}
+	}
+}
+
+func TestPrecompressedFileServer(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"app.js": "plain", "app.js.br": "brotli", "app.js.gz": "gzip", "deleted.js.br": "brotli"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := precompressedFileServer(http.Dir(dir))
+
+	cases := []struct {
+		accept, encoding, body string
+	}{
+		{"gzip, br", "br", "brotli"},
+		{"gzip", "gzip", "gzip"},
+		{"", "", "plain"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		req.Header.Set(HeaderAcceptEncoding, c.accept)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Header().Get(HeaderContentEncoding) != c.encoding || rec.Body.String() != c.body {
+			t.Errorf("%q: expected %q %q, got %q %q", c.accept, c.encoding, c.body, rec.Header().Get(HeaderContentEncoding), rec.Body.String())
+		}
+		if ct := rec.Header().Get(HeaderContentType); ct != "text/javascript; charset=utf-8" && ct != "application/javascript" {
+			t.Errorf("%q: expected a javascript content type, got %q", c.accept, ct)
+		}
+	}
+
+	// The sibling of a deleted file is not served
+	req := httptest.NewRequest(http.MethodGet, "/deleted.js", nil)
+	req.Header.Set(HeaderAcceptEncoding, "br")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted file, got %d %q", rec.Code, rec.Body.String())
+	}
+}
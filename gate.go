@@ -0,0 +1,90 @@
+package dojo
+
+import (
+	"reflect"
+	"sync"
+)
+
+// GateFunc decides if the user is allowed to perform an ability.
+type GateFunc func(user Authenticable, args ...interface{}) bool
+
+// Policy groups the abilities of one resource type, the resource is passed
+// as first argument to the GateFunc.
+type Policy map[string]GateFunc
+
+type Gate struct {
+	mu        sync.RWMutex
+	abilities map[string]GateFunc
+	policies  map[reflect.Type]Policy
+}
+
+func NewGate() *Gate {
+	return &Gate{
+		abilities: make(map[string]GateFunc),
+		policies:  make(map[reflect.Type]Policy),
+	}
+}
+
+// Define registers a new ability
+func (gate *Gate) Define(ability string, fn GateFunc) {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	gate.abilities[ability] = fn
+}
+
+// Policy registers a policy for the type of the resource, pointers and values
+// of the type share the policy.
+func (gate *Gate) Policy(resource interface{}, policy Policy) {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	gate.policies[policyType(resource)] = policy
+}
+
+// Has reports if the ability is defined
+func (gate *Gate) Has(ability string) bool {
+	gate.mu.RLock()
+	defer gate.mu.RUnlock()
+	_, ok := gate.abilities[ability]
+	return ok
+}
+
+// Allows checks the ability for the user. When the first argument has a policy
+// with the ability the policy decides, otherwise the defined ability.
+// Undefined abilities are denied.
+func (gate *Gate) Allows(user Authenticable, ability string, args ...interface{}) bool {
+	gate.mu.RLock()
+	fn, ok := gate.abilities[ability]
+	if len(args) > 0 && args[0] != nil {
+		if policy, found := gate.policies[policyType(args[0])]; found {
+			if pfn, exists := policy[ability]; exists {
+				fn, ok = pfn, true
+			}
+		}
+	}
+	gate.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+	return fn(user, args...)
+}
+
+func (gate *Gate) Denies(user Authenticable, ability string, args ...interface{}) bool {
+	return !gate.Allows(user, ability, args...)
+}
+
+// Authorize returns ErrForbidden when the user is not allowed to perform the ability
+func (gate *Gate) Authorize(user Authenticable, ability string, args ...interface{}) error {
+	if gate.Allows(user, ability, args...) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+func policyType(resource interface{}) reflect.Type {
+	t := reflect.TypeOf(resource)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,40 @@
+package dojo
+
+import (
+	"github.com/gofrs/uuid"
+	"testing"
+)
+
+type gatePost struct {
+	AuthorID uuid.UUID
+}
+
+func TestGate_Allows(t *testing.T) {
+	gate := NewGate()
+	gate.Define("edit-post", func(user Authenticable, args ...interface{}) bool {
+		return !user.IsGuest()
+	})
+	gate.Policy(gatePost{}, Policy{
+		"update": func(user Authenticable, args ...interface{}) bool {
+			return args[0].(*gatePost).AuthorID == user.GetAuthID()
+		},
+	})
+
+	author := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	other := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	guest := &AuthUser{Identifier: UUIDIdentifier(uuid.Nil)}
+	post := &gatePost{AuthorID: author.GetAuthID()}
+
+	if !gate.Allows(author, "edit-post") || gate.Allows(guest, "edit-post") {
+		t.Error("edit-post must be allowed for users only")
+	}
+	if !gate.Allows(author, "update", post) {
+		t.Error("the author must be allowed to update the post")
+	}
+	if err := gate.Authorize(other, "update", post); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+	if gate.Allows(author, "delete", post) {
+		t.Error("undefined abilities must be denied")
+	}
+}
@@ -0,0 +1,193 @@
+package dojo
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultGuardName is the session guard that backs the Authentication methods
+	DefaultGuardName = "web"
+	guardContextKey  = "auth_guard"
+)
+
+type GuardDriver string
+
+const (
+	SessionGuardDriver GuardDriver = "session"
+	JWTGuardDriver     GuardDriver = "jwt"
+	TokenGuardDriver   GuardDriver = "token"
+	BasicGuardDriver   GuardDriver = "basic"
+)
+
+// GuardAuthenticator resolves the user of a request for the stateless drivers.
+// The middleware package provides them for the jwt and basic drivers.
+type GuardAuthenticator func(ctx Context) (Authenticable, error)
+
+type GuardConfig struct {
+	Driver GuardDriver `json:"driver" yaml:"driver"`
+}
+
+// Guard authenticates the users of one area of the application. Each guard
+// keeps its user under its own session and context key, so for example an
+// admin and a customer can be logged in at the same time.
+type Guard struct {
+	auth   *Authentication
+	Name   string
+	Driver GuardDriver
+	// Users loads the users of the guard, the UserProvider of the Authentication is used when it is nil
+	Users UserProvider
+	// Authenticator is required by the jwt and basic drivers
+	Authenticator GuardAuthenticator
+}
+
+// DefineGuard adds or replaces the guard with the name
+func (auth *Authentication) DefineGuard(name string, driver GuardDriver) *Guard {
+	g := &Guard{auth: auth, Name: name, Driver: driver}
+	auth.guards[name] = g
+	return g
+}
+
+// Guard returns the guard with the name, it panics for unknown guards.
+func (auth *Authentication) Guard(name string) *Guard {
+	g, err := auth.FindGuard(name)
+	if err != nil {
+		panic("dojo: " + err.Error())
+	}
+	return g
+}
+
+// FindGuard returns the guard with the name or an error for unknown guards
+func (auth *Authentication) FindGuard(name string) (*Guard, error) {
+	g, ok := auth.guards[name]
+	if !ok {
+		return nil, fmt.Errorf("auth guard %q is not defined", name)
+	}
+	return g, nil
+}
+
+// UseGuard makes the guard the one of the current request, the authenticated
+// user of the request, the gates and the views are resolved with it.
+func (auth *Authentication) UseGuard(ctx Context, name string) {
+	ctx.Set(guardContextKey, auth.Guard(name).Name)
+}
+
+// CurrentGuard returns the guard of the request, the default guard when no middleware selected one
+func (auth *Authentication) CurrentGuard(ctx Context) *Guard {
+	if name, ok := ctx.Value(guardContextKey).(string); ok {
+		if g, ok := auth.guards[name]; ok {
+			return g
+		}
+	}
+	return auth.guards[DefaultGuardName]
+}
+
+func (g *Guard) sessionKey() string {
+	if g.Name == DefaultGuardName {
+		return authUserSessionKey
+	}
+	return authUserSessionKey + "_" + g.Name
+}
+
+func (g *Guard) contextKey() string {
+	if g.Name == DefaultGuardName {
+		return authUserContextKey
+	}
+	return authUserContextKey + "_" + g.Name
+}
+
+// UserProvider returns the user provider of the guard
+func (g *Guard) UserProvider() UserProvider {
+	if g.Users != nil {
+		return g.Users
+	}
+	return g.auth.Users
+}
+
+// User returns the user of the guard, a guest when nobody is authenticated
+func (g *Guard) User(ctx Context) AuthUser {
+	if user, ok := ctx.Value(g.contextKey()).(AuthUser); ok {
+		return user
+	}
+	if g.Driver == SessionGuardDriver {
+		session := ctx.Session()
+		if session.Session == nil {
+			return AuthUser{}
+		}
+		if user, ok := session.Get(g.sessionKey()).(AuthUser); ok {
+			return user
+		}
+	}
+	return AuthUser{}
+}
+
+// Check reports if a user is authenticated with the guard
+func (g *Guard) Check(ctx Context) bool {
+	user := g.User(ctx)
+	return !user.IsGuest()
+}
+
+// Login stores the user in the session, the stateless drivers only set it for the request.
+func (g *Guard) Login(ctx Context, user Authenticable) error {
+	if g.Driver != SessionGuardDriver {
+		g.SetUser(ctx, user)
+		return nil
+	}
+	session := ctx.Session()
+	session.Set(g.sessionKey(), NewAuthUser(user))
+	return session.Save()
+}
+
+// SetUser sets the user of the guard for the current request only
+func (g *Guard) SetUser(ctx Context, user Authenticable) {
+	ctx.Set(g.contextKey(), NewAuthUser(user))
+}
+
+// Logout removes the user of the guard from the session, the users of other guards stay logged in.
+func (g *Guard) Logout(ctx Context) error {
+	ctx.Set(g.contextKey(), AuthUser{})
+	if g.Driver != SessionGuardDriver {
+		return nil
+	}
+	session := ctx.Session()
+	session.Delete(g.sessionKey())
+	session.Delete(impersonatorSessionKey(g))
+	return session.Save()
+}
+
+// Authenticate resolves the user of the request with the driver of the guard
+// and reports if the request is authenticated.
+func (g *Guard) Authenticate(ctx Context) (bool, error) {
+	switch g.Driver {
+	case SessionGuardDriver:
+		return g.Check(ctx), nil
+	case TokenGuardDriver:
+		plain := bearerToken(ctx)
+		if plain == "" {
+			return false, nil
+		}
+		if err := g.auth.authenticateAccessToken(ctx, g, plain); err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		if g.Authenticator == nil {
+			return false, fmt.Errorf("dojo: auth guard %q has no authenticator for the %s driver", g.Name, g.Driver)
+		}
+		user, err := g.Authenticator(ctx)
+		if err != nil || user == nil || user.IsGuest() {
+			return false, err
+		}
+		g.SetUser(ctx, user)
+		return true, nil
+	}
+}
+
+func bearerToken(ctx Context) string {
+	const prefix = "Bearer "
+	auth := ctx.Request().Header.Get(HeaderAuthorization)
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return auth[len(prefix):]
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package dojo
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"strconv"
+)
+
+type IdentifierKind uint8
+
+const (
+	NoIdentifier IdentifierKind = iota
+	IntIdentifierKind
+	StringIdentifierKind
+	UUIDIdentifierKind
+)
+
+// Identifier identifies a user by an int64, string or uuid primary key. All
+// fields are exported, so it can be stored in the gob encoded sessions.
+type Identifier struct {
+	Kind IdentifierKind
+	Int  int64
+	Str  string
+	UUID uuid.UUID
+}
+
+// IdentifiableAuth can be implemented by an Authenticable whose primary key
+// isn't a uuid, GetAuthID should return uuid.Nil then.
+type IdentifiableAuth interface {
+	GetAuthIdentifier() Identifier
+}
+
+func IntIdentifier(id int64) Identifier {
+	return Identifier{Kind: IntIdentifierKind, Int: id}
+}
+
+func StringIdentifier(id string) Identifier {
+	if id == "" {
+		return Identifier{}
+	}
+	return Identifier{Kind: StringIdentifierKind, Str: id}
+}
+
+func UUIDIdentifier(id uuid.UUID) Identifier {
+	if id == uuid.Nil {
+		return Identifier{}
+	}
+	return Identifier{Kind: UUIDIdentifierKind, UUID: id}
+}
+
+// AuthIdentifier returns the identifier of the user, the uuid of GetAuthID
+// is used when the user doesn't implement IdentifiableAuth.
+func AuthIdentifier(user Authenticable) Identifier {
+	if i, ok := user.(IdentifiableAuth); ok {
+		if id := i.GetAuthIdentifier(); !id.IsZero() {
+			return id
+		}
+	}
+	return UUIDIdentifier(user.GetAuthID())
+}
+
+// IsZero reports if the identifier is empty, users without identifier are guests
+func (id Identifier) IsZero() bool {
+	return id.Kind == NoIdentifier
+}
+
+func (id Identifier) Equal(other Identifier) bool {
+	return id == other
+}
+
+// String returns the identifier as it is stored by the token and role stores
+func (id Identifier) String() string {
+	switch id.Kind {
+	case IntIdentifierKind:
+		return strconv.FormatInt(id.Int, 10)
+	case StringIdentifierKind:
+		return id.Str
+	case UUIDIdentifierKind:
+		return id.UUID.String()
+	}
+	return ""
+}
+
+func (id Identifier) MarshalJSON() ([]byte, error) {
+	switch id.Kind {
+	case IntIdentifierKind:
+		return json.Marshal(id.Int)
+	case NoIdentifier:
+		return []byte("null"), nil
+	}
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON reads the identifiers of MarshalJSON, numbers are int
+// identifiers and strings are uuid identifiers when they parse as uuid.
+func (id *Identifier) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*id = Identifier{}
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*id = IntIdentifier(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if u, err := uuid.FromString(s); err == nil {
+		*id = UUIDIdentifier(u)
+		return nil
+	}
+	*id = StringIdentifier(s)
+	return nil
+}
@@ -0,0 +1,90 @@
+package dojo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"testing"
+)
+
+type legacyUser struct {
+	id int64
+}
+
+func (u *legacyUser) GetAuthType() AuthUserType     { return UserUserType }
+func (u *legacyUser) GetAuthID() uuid.UUID          { return uuid.Nil }
+func (u *legacyUser) GetAuthData() interface{}      { return nil }
+func (u *legacyUser) IsGuest() bool                 { return false }
+func (u *legacyUser) GetAuthIdentifier() Identifier { return IntIdentifier(u.id) }
+
+func TestNewAuthUser_Identifiers(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+	cases := []struct {
+		name   string
+		user   Authenticable
+		expect string
+	}{
+		{"uuid", &AuthUser{Identifier: UUIDIdentifier(id)}, id.String()},
+		{"int", &legacyUser{id: 42}, "42"},
+		{"string", &AuthUser{Identifier: StringIdentifier("auth0|123")}, "auth0|123"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user := NewAuthUser(c.user)
+			if user.IsGuest() {
+				t.Fatal("a user with an identifier must not be a guest")
+			}
+
+			// The users are gob encoded in the session
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(user); err != nil {
+				t.Fatal(err)
+			}
+			var decoded AuthUser
+			if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.GetAuthIdentifier().String(); got != c.expect {
+				t.Errorf("expected the identifier %s, got %s", c.expect, got)
+			}
+		})
+	}
+
+	guest := AuthUser{}
+	if !guest.IsGuest() {
+		t.Error("a user without identifier must be a guest")
+	}
+}
+
+func TestIdentifier_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal([]Identifier{IntIdentifier(7), StringIdentifier("abc"), {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `[7,"abc",null]` {
+		t.Errorf("unexpected json %s", b)
+	}
+}
+
+func TestIdentifier_UnmarshalJSON(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+	in := []Identifier{IntIdentifier(7), StringIdentifier("auth0|123"), UUIDIdentifier(id), {}}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []Identifier
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	for i := range in {
+		if !out[i].Equal(in[i]) {
+			t.Errorf("expected %#v, got %#v", in[i], out[i])
+		}
+	}
+	if err := json.Unmarshal([]byte(`true`), &out[0]); err == nil {
+		t.Error("expected an error for a bool identifier")
+	}
+}
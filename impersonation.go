@@ -0,0 +1,123 @@
+package dojo
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+)
+
+// ImpersonateAbility is the gate ability that is checked before a user can impersonate another one
+const ImpersonateAbility = "impersonate"
+
+var (
+	ErrAlreadyImpersonating = errors.New("the user is already impersonating another user")
+	ErrNotImpersonating     = errors.New("the user is not impersonating another user")
+)
+
+func impersonatorSessionKey(guard *Guard) string {
+	return guard.sessionKey() + "_impersonator"
+}
+
+// Impersonate logs the current user in as the target. The original identity
+// is kept in the session until StopImpersonating. The "impersonate" gate
+// ability decides who can impersonate whom, it receives the target as argument.
+func (auth *Authentication) Impersonate(ctx Context, target Authenticable) error {
+	guard := auth.CurrentGuard(ctx)
+	if guard.Driver != SessionGuardDriver {
+		return errors.New("dojo: impersonation needs a session guard")
+	}
+	user := guard.User(ctx)
+	if user.IsGuest() {
+		return ErrUnauthorized
+	}
+	if auth.IsImpersonating(ctx) {
+		return ErrAlreadyImpersonating
+	}
+	if err := auth.dojo.Gate.Authorize(&user, ImpersonateAbility, target); err != nil {
+		return err
+	}
+
+	session := ctx.Session()
+	session.Set(impersonatorSessionKey(guard), user)
+	auth.dojo.Logger.WithFields(logrus.Fields{
+		"event":           "impersonation_started",
+		"impersonator_id": user.GetAuthIdentifier().String(),
+		"user_id":         AuthIdentifier(target).String(),
+	}).Info("impersonation started")
+	return guard.Login(ctx, target)
+}
+
+// StopImpersonating logs the original user back in
+func (auth *Authentication) StopImpersonating(ctx Context) error {
+	guard := auth.CurrentGuard(ctx)
+	impersonator, ok := auth.Impersonator(ctx)
+	if !ok {
+		return ErrNotImpersonating
+	}
+
+	user := guard.User(ctx)
+	session := ctx.Session()
+	session.Delete(impersonatorSessionKey(guard))
+	auth.dojo.Logger.WithFields(logrus.Fields{
+		"event":           "impersonation_stopped",
+		"impersonator_id": impersonator.GetAuthIdentifier().String(),
+		"user_id":         user.GetAuthIdentifier().String(),
+	}).Info("impersonation stopped")
+	return guard.Login(ctx, &impersonator)
+}
+
+// Impersonator returns the original user when the current user is impersonated
+func (auth *Authentication) Impersonator(ctx Context) (AuthUser, bool) {
+	guard := auth.CurrentGuard(ctx)
+	if guard.Driver != SessionGuardDriver {
+		return AuthUser{}, false
+	}
+	session := ctx.Session()
+	if session.Session == nil {
+		return AuthUser{}, false
+	}
+	user, ok := session.Get(impersonatorSessionKey(guard)).(AuthUser)
+	return user, ok
+}
+
+func (auth *Authentication) IsImpersonating(ctx Context) bool {
+	_, ok := auth.Impersonator(ctx)
+	return ok
+}
+
+// logImpersonation logs the request with both ids when the session carries
+// an impersonation of a session guard. It runs for every request before the
+// middlewares, no matter if the route is authenticated.
+func (auth *Authentication) logImpersonation(ctx Context) {
+	session := ctx.Session()
+	if session == nil || session.Session == nil {
+		return
+	}
+
+	names := make([]string, 0, len(auth.guards))
+	for name := range auth.guards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	req := ctx.Request()
+	for _, name := range names {
+		guard := auth.guards[name]
+		if guard.Driver != SessionGuardDriver {
+			continue
+		}
+		impersonator, ok := session.Get(impersonatorSessionKey(guard)).(AuthUser)
+		if !ok {
+			continue
+		}
+		user := guard.User(ctx)
+		auth.dojo.Logger.WithFields(logrus.Fields{
+			"event":           "impersonated_request",
+			"guard":           guard.Name,
+			"impersonator_id": impersonator.GetAuthIdentifier().String(),
+			"user_id":         user.GetAuthIdentifier().String(),
+			"method":          req.Method,
+			"uri":             req.URL.RequestURI(),
+		}).Info("impersonated request")
+	}
+}
@@ -0,0 +1,128 @@
+package dojo
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthentication_Impersonate(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	app.Gate.Define(ImpersonateAbility, func(user Authenticable, args ...interface{}) bool {
+		return user.GetAuthData() == "support"
+	})
+
+	support := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: "support"}
+	customer := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: "customer"}
+
+	app.Route.Get("/login-support", func(ctx Context) error { return ctx.Dojo().Auth.Login(ctx, support) })
+	app.Route.Get("/login-customer", func(ctx Context) error { return ctx.Dojo().Auth.Login(ctx, customer) })
+	app.Route.Get("/impersonate", func(ctx Context) error { return ctx.Dojo().Auth.Impersonate(ctx, customer) })
+	app.Route.Get("/stop", func(ctx Context) error { return ctx.Dojo().Auth.StopImpersonating(ctx) })
+	app.Route.Get("/me", func(ctx Context) error {
+		return ctx.JSON(http.StatusOK, map[string]interface{}{
+			"id":            ctx.Dojo().Auth.GetAuthUser(ctx).Identifier.String(),
+			"impersonating": ctx.Dojo().Auth.IsImpersonating(ctx),
+		})
+	})
+
+	var cookies []*http.Cookie
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if set := rec.Result().Cookies(); len(set) > 0 {
+			cookies = set
+		}
+		return rec
+	}
+	me := func() (id string, impersonating bool) {
+		var body struct {
+			Data struct {
+				ID            string `json:"id"`
+				Impersonating bool   `json:"impersonating"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(do("/me").Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Data.ID, body.Data.Impersonating
+	}
+
+	do("/login-customer")
+	if rec := do("/impersonate"); rec.Code != http.StatusForbidden {
+		t.Fatalf("customers must not impersonate, got %d", rec.Code)
+	}
+
+	do("/login-support")
+	do("/impersonate")
+	if id, impersonating := me(); id != customer.Identifier.String() || !impersonating {
+		t.Fatalf("expected to be impersonating the customer, got %s %v", id, impersonating)
+	}
+
+	do("/stop")
+	if id, impersonating := me(); id != support.Identifier.String() || impersonating {
+		t.Fatalf("expected to be back as support, got %s %v", id, impersonating)
+	}
+}
+
+func TestAuthentication_LogsImpersonatedRequests(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	hook := test.NewLocal(app.Logger)
+	app.Gate.Define(ImpersonateAbility, func(user Authenticable, args ...interface{}) bool {
+		return true
+	})
+	support := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	customer := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	app.Route.Get("/login", func(ctx Context) error { return ctx.Dojo().Auth.Login(ctx, support) })
+	app.Route.Get("/impersonate", func(ctx Context) error { return ctx.Dojo().Auth.Impersonate(ctx, customer) })
+	// The route is not authenticated, the request is logged anyway
+	app.Route.Get("/orders", func(ctx Context) error { return ctx.NoContent(http.StatusNoContent) })
+
+	var cookies []*http.Cookie
+	serve := func(handler http.Handler, path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if set := rec.Result().Cookies(); len(set) > 0 {
+			cookies = set
+		}
+	}
+	serve(app.Route.GetMux(), "/login")
+	serve(app.Route.GetMux(), "/impersonate")
+
+	// Handler also sees the requests no route matches
+	cases := []struct {
+		handler http.Handler
+		path    string
+	}{
+		{app.Route.GetMux(), "/orders?page=2"},
+		{app.Handler(), "/orders?page=2"},
+		{app.Handler(), "/missing"},
+	}
+	for _, c := range cases {
+		hook.Reset()
+		serve(c.handler, c.path)
+		entries := hook.AllEntries()
+		if len(entries) != 1 || entries[0].Data["event"] != "impersonated_request" ||
+			entries[0].Data["user_id"] != customer.Identifier.String() ||
+			entries[0].Data["impersonator_id"] != support.Identifier.String() ||
+			entries[0].Data["uri"] != c.path {
+			t.Errorf("%s: expected the impersonated request to be logged once, got %v", c.path, entries)
+		}
+	}
+}
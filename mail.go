@@ -0,0 +1,130 @@
+package dojo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// MailMessage is a plain text mail, HTML is sent as alternative when set
+type MailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// ErrInvalidMailHeader is returned for a recipient or subject with a line break,
+// it would let the value add headers to the mail.
+var ErrInvalidMailHeader = errors.New("the mail recipient and subject must not contain line breaks")
+
+// Mailer delivers the mails of the application
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+// LogMailer writes the mails to the log instead of sending them, it is meant for development
+type LogMailer struct {
+	Logger *logrus.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg MailMessage) error {
+	m.Logger.WithFields(logrus.Fields{
+		"event":   "mail",
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Info(msg.Text)
+	return nil
+}
+
+// SMTPMailer sends the mails with a smtp server, the delivery is cancelled
+// with the context.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg MailMessage) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return ErrInvalidMailHeader
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", m.From, msg.To, msg.Subject)
+	if msg.HTML == "" {
+		fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n\r\n%s", msg.Text)
+	} else {
+		boundary := "dojo-alternative"
+		fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+		fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.Text)
+		fmt.Fprintf(&b, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+		fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	}
+	return m.send(ctx, msg.To, []byte(b.String()))
+}
+
+// send works like smtp.SendMail, the connection is closed when ctx is done
+func (m *SMTPMailer) send(ctx context.Context, to string, body []byte) (err error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer func() {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(m.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Auth != nil {
+		if err := c.Auth(m.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,51 @@
+package dojo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSMTPMailer_RejectsLineBreaks(t *testing.T) {
+	mailer := &SMTPMailer{Addr: "127.0.0.1:0", From: "app@example.com"}
+
+	messages := []MailMessage{
+		{To: "john@example.com\r\nBcc: eve@example.com", Subject: "Hello"},
+		{To: "john@example.com", Subject: "Hello\nBcc: eve@example.com"},
+	}
+	for _, msg := range messages {
+		if err := mailer.Send(context.Background(), msg); err != ErrInvalidMailHeader {
+			t.Errorf("expected ErrInvalidMailHeader for %q, got %v", msg.To+" "+msg.Subject, err)
+		}
+	}
+}
+
+func TestSMTPMailer_HonoursTheContext(t *testing.T) {
+	// The server accepts the connection but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	mailer := &SMTPMailer{Addr: ln.Addr().String(), From: "app@example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = mailer.Send(ctx, MailMessage{To: "john@example.com", Subject: "Hello", Text: "Hi"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline error, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("expected the delivery to stop with the context")
+	}
+}
@@ -1,7 +1,11 @@
 package dojo
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 )
 
 type MiddlewareFunc func(Handler) Handler
@@ -14,54 +18,156 @@ func (ms *MiddlewareStack) Use(mw ...MiddlewareFunc) {
 	ms.stack = append(ms.stack, mw...)
 }
 
+// handler wraps the handler of the route, the first middleware is the outermost
 func (ms *MiddlewareStack) handler(rc RouteConfig) Handler {
 	h := rc.Handler
-	if len(ms.stack) > 0 {
-		mh := func(_ Handler) Handler {
-			return h
-		}
-		tstack := []MiddlewareFunc{mh}
-		sl := len(ms.stack) - 1
-		for i := sl; i >= 0; i-- {
-			mw := ms.stack[i]
-			tstack = append(tstack, mw)
-		}
-
-		for _, mw := range tstack {
-			h = mw(h)
-		}
-		return h
+	for i := len(ms.stack) - 1; i >= 0; i-- {
+		h = ms.stack[i](h)
 	}
 	return h
 }
 
+// MiddlewareFactory creates a middleware from the arguments of a spec like
+// "throttle:60,1", the arguments are "60" and "1".
+type MiddlewareFactory func(args ...string) (MiddlewareFunc, error)
+
 type Middleware struct {
 	Name    string
 	Handler MiddlewareFunc
+	Factory MiddlewareFactory
 }
 
 type MiddlewareRegistry struct {
 	middlewares []Middleware
 	stacks      map[string][]string
+	priority    []string
+}
+
+// DefaultMiddlewarePriority is the order of the registered middlewares on a
+// route, no matter in which order they are used. The logging is outside of
+// the recover middleware, so the requests that panic are logged too.
+var DefaultMiddlewarePriority = []string{
+	"request_id",
+	"logging",
+	"recover",
+	"compress",
+	"etag",
+	"secure",
+	"cors",
+	"timeout",
+	"csrf",
+	"auth",
+	"guest",
+	"two_factor",
+	"verified",
+	"abilities",
+	"throttle",
+	"cache",
+	"role",
+	"permission",
+	"can",
 }
 
 func NewMiddlewareRegistry() *MiddlewareRegistry {
 	return &MiddlewareRegistry{
-		stacks: make(map[string][]string),
+		stacks:   make(map[string][]string),
+		priority: DefaultMiddlewarePriority,
 	}
 }
 
 func (registry *MiddlewareRegistry) Register(name string, fn MiddlewareFunc) {
-	registry.middlewares = append(registry.middlewares, Middleware{
+	registry.add(Middleware{
 		Name:    name,
 		Handler: fn,
 	})
 }
 
+// RegisterFactory registers a middleware that is created with the arguments of
+// the name it is used with, Use("role:admin,editor") calls the factory of
+// "role" with "admin" and "editor".
+func (registry *MiddlewareRegistry) RegisterFactory(name string, factory MiddlewareFactory) {
+	registry.add(Middleware{
+		Name:    name,
+		Factory: factory,
+	})
+}
+
 func (registry *MiddlewareRegistry) RegisterStack(name string, middlewares []string) {
 	registry.stacks[name] = middlewares
 }
 
+// SetPriority replaces the priority list. The middlewares of a route that are
+// in the list are sorted by it, the others keep their position.
+func (registry *MiddlewareRegistry) SetPriority(names ...string) {
+	registry.priority = names
+}
+
+// sortByPriority sorts the specs like "throttle:60,1" by the name of their middleware
+func (registry MiddlewareRegistry) sortByPriority(specs []string) []string {
+	rank := make(map[string]int, len(registry.priority))
+	for i, name := range registry.priority {
+		rank[name] = i
+	}
+
+	var positions []int
+	var ranked []string
+	for i, spec := range specs {
+		if _, ok := rank[middlewareName(spec)]; ok {
+			positions = append(positions, i)
+			ranked = append(ranked, spec)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rank[middlewareName(ranked[i])] < rank[middlewareName(ranked[j])]
+	})
+
+	sorted := append([]string(nil), specs...)
+	for i, pos := range positions {
+		sorted[pos] = ranked[i]
+	}
+	return sorted
+}
+
+// preflightMiddlewares returns the middlewares of the specs that run before
+// cors in the priority, cors included, sorted by the priority. Preflights
+// carry no credentials, so they must not reach auth, csrf or the throttles.
+func (registry MiddlewareRegistry) preflightMiddlewares(specs []string) []string {
+	limit := -1
+	rank := make(map[string]int, len(registry.priority))
+	for i, name := range registry.priority {
+		rank[name] = i
+		if name == "cors" {
+			limit = i
+		}
+	}
+
+	var preflight []string
+	for _, spec := range registry.sortByPriority(specs) {
+		if i, ok := rank[middlewareName(spec)]; ok && i <= limit {
+			preflight = append(preflight, spec)
+		}
+	}
+	return preflight
+}
+
+func middlewareName(spec string) string {
+	if i := strings.Index(spec, ":"); i >= 0 {
+		return spec[:i]
+	}
+	return spec
+}
+
+// add replaces the middleware with the same name
+func (registry *MiddlewareRegistry) add(m Middleware) {
+	for i := range registry.middlewares {
+		if registry.middlewares[i].Name == m.Name {
+			registry.middlewares[i] = m
+			return
+		}
+	}
+	registry.middlewares = append(registry.middlewares, m)
+}
+
 func (registry MiddlewareRegistry) findMiddleware(name string) (Middleware, error) {
 	for _, m := range registry.middlewares {
 		if m.Name == name {
@@ -70,3 +176,68 @@ func (registry MiddlewareRegistry) findMiddleware(name string) (Middleware, erro
 	}
 	return Middleware{}, fmt.Errorf("middleware %s is not registered", name)
 }
+
+// Resolve returns the middleware of a spec like "auth" or "throttle:60,1"
+func (registry MiddlewareRegistry) Resolve(spec string) (MiddlewareFunc, error) {
+	name, params := middlewareName(spec), ""
+	if len(spec) > len(name) {
+		params = spec[len(name)+1:]
+	}
+
+	m, err := registry.findMiddleware(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Factory == nil {
+		if params != "" {
+			return nil, fmt.Errorf("middleware %s does not take arguments", name)
+		}
+		return m.Handler, nil
+	}
+
+	var args []string
+	if params != "" {
+		for _, arg := range strings.Split(params, ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	}
+	mw, err := m.Factory(args...)
+	if err != nil {
+		return nil, fmt.Errorf("middleware %s: %w", name, err)
+	}
+	return mw, nil
+}
+
+type contextKey string
+
+// requestContextKey carries the context of the global middlewares to the route
+const requestContextKey contextKey = "dojo.context"
+
+// Use adds a global middleware. The global middlewares run for every request
+// of Handler before the route is matched, also for 404s and the assets.
+func (dojo *Dojo) Use(middlewares ...MiddlewareFunc) {
+	dojo.globalMiddlewares.Use(middlewares...)
+}
+
+// Handler returns the http.Handler of the application, it runs the global
+// middlewares and then the router. The routes share the context with them.
+func (dojo *Dojo) Handler() http.Handler {
+	router := dojo.Route.GetMux()
+	h := dojo.globalMiddlewares.handler(RouteConfig{
+		Handler: func(ctx Context) error {
+			req := ctx.Request()
+			req = req.WithContext(context.WithValue(req.Context(), requestContextKey, ctx))
+			router.ServeHTTP(ctx.Response(), req)
+			return nil
+		},
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := dojo.NewContext(RouteConfig{Dojo: dojo}, w, r)
+		dojo.Auth.logImpersonation(c)
+		if err := h(c); err != nil {
+			dojo.HTTPErrorHandler(err, c)
+		}
+	})
+}
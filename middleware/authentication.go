@@ -1,15 +1,24 @@
 package middleware
 
 import (
+	"errors"
 	"github.com/zengineDev/dojo"
 	"net/http"
 )
 
 type (
 	AuthenticationConfig struct {
+		// Auth is the authentication of the app, its guards are resolved when
+		// the middleware is created. Required.
+		Auth         *dojo.Authentication
 		Skipper      Skipper
 		BeforeFunc   BeforeFunc
 		RedirectPath string
+		// Challenge is sent as WWW-Authenticate header to json and xhr clients
+		Challenge string
+		// Guards are tried in order, the first one that authenticates the
+		// request becomes the guard of the route. The default guard is used when it is empty.
+		Guards []string
 	}
 )
 
@@ -17,27 +26,125 @@ var (
 	DefaultAuthenticationConfig = AuthenticationConfig{
 		Skipper:      DefaultSkipper,
 		RedirectPath: "/login",
+		Challenge:    `Bearer realm="dojo"`,
 	}
 )
 
-func Authentication() dojo.MiddlewareFunc {
+func Authentication(auth *dojo.Authentication, guards ...string) dojo.MiddlewareFunc {
 	config := DefaultAuthenticationConfig
+	config.Auth = auth
+	config.Guards = guards
 	return AuthenticationWithConfig(config)
 }
 
 func AuthenticationWithConfig(config AuthenticationConfig) dojo.MiddlewareFunc {
+	guards, err := authenticationGuards(config)
+	if err != nil {
+		panic("dojo: " + err.Error())
+	}
+	return authentication(config, guards)
+}
+
+// authenticationGuards resolves the guards of the config, the default guard
+// when it names none
+func authenticationGuards(config AuthenticationConfig) ([]*dojo.Guard, error) {
+	if config.Auth == nil {
+		return nil, errors.New("authentication middleware requires the auth of the app")
+	}
+	names := config.Guards
+	if len(names) == 0 {
+		names = []string{dojo.DefaultGuardName}
+	}
+	guards := make([]*dojo.Guard, 0, len(names))
+	for _, name := range names {
+		g, err := config.Auth.FindGuard(name)
+		if err != nil {
+			return nil, err
+		}
+		guards = append(guards, g)
+	}
+	return guards, nil
+}
 
+func authentication(config AuthenticationConfig, guards []*dojo.Guard) dojo.MiddlewareFunc {
 	if config.Skipper == nil {
 		config.Skipper = DefaultAuthenticationConfig.Skipper
 	}
+	if config.RedirectPath == "" {
+		config.RedirectPath = DefaultAuthenticationConfig.RedirectPath
+	}
+	if config.Challenge == "" {
+		config.Challenge = DefaultAuthenticationConfig.Challenge
+	}
 
 	return func(next dojo.Handler) dojo.Handler {
 		return func(context dojo.Context) error {
-			user := context.Dojo().Auth.GetAuthUser(context)
-			if user.IsGuest() {
-				http.Redirect(context.Response(), context.Request(), config.RedirectPath, http.StatusFound)
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			auth := config.Auth
+			for _, guard := range guards {
+				ok, err := authenticateGuard(context, auth, guard)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return next(context)
+				}
 			}
-			return next(context)
+			auth.UseGuard(context, guards[0].Name)
+
+			// Only session guards can send the browser to a login page
+			if dojo.ExpectsJSON(context) || guards[0].Driver != dojo.SessionGuardDriver {
+				if context.Response().Header().Get(dojo.HeaderWWWAuthenticate) == "" {
+					context.Response().Header().Set(dojo.HeaderWWWAuthenticate, config.Challenge)
+				}
+				return dojo.ErrUnauthorized
+			}
+
+			req := context.Request()
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				if err := auth.SetIntendedURL(context, req.URL.RequestURI()); err != nil {
+					return err
+				}
+			}
+			http.Redirect(context.Response(), req, config.RedirectPath, http.StatusFound)
+			return nil
+		}
+	}
+}
+
+// authenticateGuard makes the guard the one of the request and tries to
+// authenticate it. Rejected credentials are no error, the next guard is tried.
+func authenticateGuard(ctx dojo.Context, auth *dojo.Authentication, guard *dojo.Guard) (bool, error) {
+	auth.UseGuard(ctx, guard.Name)
+
+	ok, err := guard.Authenticate(ctx)
+	if err != nil {
+		var he *dojo.HTTPError
+		if errors.Is(err, dojo.ErrAccessTokenInvalid) || errors.Is(err, dojo.ErrAccessTokenExpired) ||
+			(errors.As(err, &he) && he.Code == http.StatusUnauthorized) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !ok && guard.Name == dojo.DefaultGuardName {
+		// Try to re-establish the session from the remember me cookie
+		// A replayed or unknown token leaves the request unauthenticated, the
+		// errors of the stores are returned
+		remembered, err := auth.LoginViaRemember(ctx)
+		if err != nil && !errors.Is(err, dojo.ErrRememberTokenTheft) && !errors.Is(err, dojo.ErrRememberTokenNotFound) {
+			return false, err
+		}
+		if remembered {
+			return true, nil
 		}
 	}
+	return ok, nil
 }
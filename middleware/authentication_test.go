@@ -0,0 +1,413 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"github.com/steinfletcher/apitest"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newAuthApp() *dojo.Dojo {
+	app := dojo.New(dojo.DefaultConfiguration{
+		Session: dojo.SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+
+	app.Route.Get("/login-as", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4()))})
+	})
+	app.Route.Get("/intended", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, ctx.Dojo().Auth.IntendedURL(ctx, "/home"))
+	})
+	return app
+}
+
+func loginCookies(t *testing.T, app *dojo.Dojo) []*apitest.Cookie {
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login-as", nil))
+	cookies := toAPITestCookies(rec.Result().Cookies())
+	if len(cookies) == 0 {
+		t.Fatal("login did not set a session cookie")
+	}
+	return cookies
+}
+
+func toAPITestCookies(cookies []*http.Cookie) []*apitest.Cookie {
+	var result []*apitest.Cookie
+	for _, c := range cookies {
+		result = append(result, apitest.NewCookie(c.Name).Value(c.Value))
+	}
+	return result
+}
+
+func protectedHandler(called *bool) dojo.Handler {
+	return func(ctx dojo.Context) error {
+		*called = true
+		return ctx.JSON(http.StatusOK, "secret")
+	}
+}
+
+func TestAuthentication_RedirectsGuestsAndStopsTheChain(t *testing.T) {
+	app := newAuthApp()
+	called := false
+	app.Route.Get("/dashboard", protectedHandler(&called), Authentication(app.Auth))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard?tab=1", nil))
+
+	if rec.Code != http.StatusFound || rec.Header().Get(dojo.HeaderLocation) != "/login" {
+		t.Fatalf("expected a redirect to /login, got %d %s", rec.Code, rec.Header().Get(dojo.HeaderLocation))
+	}
+	if called {
+		t.Fatal("the protected handler must not run for guests")
+	}
+
+	// The intended url is remembered for the redirect after the login
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/intended").
+		Cookies(toAPITestCookies(rec.Result().Cookies())...).
+		Expect(t).
+		Body(`{"data":"/dashboard?tab=1"}`).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestAuthentication_UnauthorizedForJSONClients(t *testing.T) {
+	app := newAuthApp()
+	called := false
+	app.Route.Get("/api/me", protectedHandler(&called), Authentication(app.Auth))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/api/me").
+		Header(dojo.HeaderAccept, dojo.MIMEApplicationJSON).
+		Expect(t).
+		Header(dojo.HeaderWWWAuthenticate, DefaultAuthenticationConfig.Challenge).
+		Status(http.StatusUnauthorized).
+		End()
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/api/me").
+		Header(dojo.HeaderXRequestedWith, "XMLHttpRequest").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	if called {
+		t.Fatal("the protected handler must not run for guests")
+	}
+}
+
+func TestAuthentication_AllowsUsers(t *testing.T) {
+	app := newAuthApp()
+	called := false
+	app.Route.Get("/dashboard", protectedHandler(&called), Authentication(app.Auth))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/dashboard").
+		Cookies(loginCookies(t, app)...).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	if !called {
+		t.Fatal("the protected handler must run for users")
+	}
+}
+
+func TestAuthentication_SkipperAndBeforeFunc(t *testing.T) {
+	app := newAuthApp()
+	before := 0
+	config := DefaultAuthenticationConfig
+	config.Auth = app.Auth
+	config.BeforeFunc = func(ctx dojo.Context) { before++ }
+	config.Skipper = func(ctx dojo.Context) bool {
+		return ctx.Request().URL.Query().Get("skip") == "1"
+	}
+	called := false
+	app.Route.Get("/dashboard", protectedHandler(&called), AuthenticationWithConfig(config))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/dashboard").
+		Query("skip", "1").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if !called || before != 0 {
+		t.Fatalf("skipped requests must reach the handler without the before func, called=%v before=%d", called, before)
+	}
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/dashboard").
+		Expect(t).
+		Status(http.StatusFound).
+		End()
+	if before != 1 {
+		t.Fatalf("the before func must run once, got %d", before)
+	}
+}
+
+func TestGuest_RedirectsUsers(t *testing.T) {
+	app := newAuthApp()
+	called := false
+	app.Route.Get("/register", protectedHandler(&called), Guest())
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/register").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if !called {
+		t.Fatal("guests must reach the handler")
+	}
+
+	called = false
+	cookies := loginCookies(t, app)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/register").
+		Cookies(cookies...).
+		Expect(t).
+		Header(dojo.HeaderLocation, "/").
+		Status(http.StatusFound).
+		End()
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/register").
+		Header(dojo.HeaderAccept, dojo.MIMEApplicationJSON).
+		Cookies(cookies...).
+		Expect(t).
+		Status(http.StatusForbidden).
+		End()
+
+	if called {
+		t.Fatal("users must not reach guest only handlers")
+	}
+}
+
+func TestGuest_SkipperAndBeforeFunc(t *testing.T) {
+	app := newAuthApp()
+	before := 0
+	config := DefaultGuestConfig
+	config.BeforeFunc = func(ctx dojo.Context) { before++ }
+	config.Skipper = func(ctx dojo.Context) bool { return true }
+	called := false
+	app.Route.Get("/register", protectedHandler(&called), GuestWithConfig(config))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/register").
+		Cookies(loginCookies(t, app)...).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if !called || before != 0 {
+		t.Fatalf("skipped requests must reach the handler without the before func, called=%v before=%d", called, before)
+	}
+}
+
+func TestAuthentication_NamedGuards(t *testing.T) {
+	app := newAuthApp()
+	app.Auth.DefineGuard("admin", dojo.SessionGuardDriver)
+	admin := uuid.Must(uuid.NewV4())
+
+	app.Route.Get("/admin/login-as", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.Guard("admin").Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(admin)})
+	})
+	app.Route.Get("/admin", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, ctx.Dojo().Auth.GetAuthUser(ctx).Identifier.String())
+	}, Authentication(app.Auth, "admin"))
+
+	// A user of the web guard is no admin
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/admin").
+		Cookies(loginCookies(t, app)...).
+		Header(dojo.HeaderAccept, dojo.MIMEApplicationJSON).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/login-as", nil))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/admin").
+		Cookies(toAPITestCookies(rec.Result().Cookies())...).
+		Expect(t).
+		Body(`{"data":"` + admin.String() + `"}`).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestAuthentication_LogoutKeepsOtherGuards(t *testing.T) {
+	app := newAuthApp()
+	app.Auth.DefineGuard("admin", dojo.SessionGuardDriver)
+
+	app.Route.Get("/admin/login-as", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.Guard("admin").Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4()))})
+	})
+	app.Route.Get("/logout", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.Logout(ctx)
+	})
+	app.Route.Get("/intended-value", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.SetIntendedURL(ctx, "/reports")
+	})
+	called := false
+	app.Route.Get("/dashboard", protectedHandler(&called), Authentication(app.Auth))
+	app.Route.Get("/admin", protectedHandler(&called), Authentication(app.Auth, "admin"))
+
+	var cookies []*http.Cookie
+	for _, path := range []string{"/login-as", "/admin/login-as", "/intended-value", "/logout"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if set := rec.Result().Cookies(); len(set) > 0 {
+			cookies = set
+		}
+	}
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/dashboard").
+		Cookies(toAPITestCookies(cookies)...).
+		Header(dojo.HeaderAccept, dojo.MIMEApplicationJSON).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/admin").
+		Cookies(toAPITestCookies(cookies)...).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/intended").
+		Cookies(toAPITestCookies(cookies)...).
+		Expect(t).
+		Body(`{"data":"/reports"}`).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestAuthentication_TriesGuardsInOrder(t *testing.T) {
+	app := newAuthApp()
+	app.Auth.DefineGuard("api", dojo.BasicGuardDriver).Authenticator = BasicAuthenticator(BasicAuthConfig{
+		Validator: func(username, password string, ctx dojo.Context) (bool, error) {
+			return username == "joe" && password == "secret", nil
+		},
+		UserMapper: func(ctx dojo.Context, username string) (dojo.Authenticable, error) {
+			return &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: username}, nil
+		},
+	})
+	called := false
+	app.Route.Get("/reports", protectedHandler(&called), Authentication(app.Auth, "web", "api"))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/reports").
+		BasicAuth("joe", "secret").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if !called {
+		t.Fatal("the second guard should authenticate the request")
+	}
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/reports").
+		BasicAuth("joe", "wrong").
+		Header(dojo.HeaderAccept, dojo.MIMEApplicationJSON).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+// failingRememberTokens is a remember token store whose database is down
+type failingRememberTokens struct {
+	err error
+}
+
+func (s failingRememberTokens) Create(ctx context.Context, token dojo.RememberToken) error {
+	return s.err
+}
+
+func (s failingRememberTokens) Find(ctx context.Context, selector string) (dojo.RememberToken, error) {
+	return dojo.RememberToken{}, s.err
+}
+
+func (s failingRememberTokens) Update(ctx context.Context, token dojo.RememberToken, oldHash string) error {
+	return s.err
+}
+
+func (s failingRememberTokens) Delete(ctx context.Context, selector string) error {
+	return s.err
+}
+
+func (s failingRememberTokens) DeleteForUser(ctx context.Context, userID string) error {
+	return s.err
+}
+
+func TestAuthentication_RememberErrors(t *testing.T) {
+	cases := map[error]int{
+		errors.New("database is down"): http.StatusInternalServerError,
+		dojo.ErrRememberTokenNotFound:  http.StatusUnauthorized,
+	}
+	for storeErr, code := range cases {
+		app := newAuthApp()
+		app.Auth.Users = tokenTestUsers{}
+		app.Auth.RememberTokens = failingRememberTokens{err: storeErr}
+		called := false
+		app.Route.Get("/dashboard", protectedHandler(&called), Authentication(app.Auth))
+
+		req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		req.Header.Set(dojo.HeaderAccept, dojo.MIMEApplicationJSON)
+		req.AddCookie(&http.Cookie{Name: "remember_token", Value: "selector:validator"})
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if rec.Code != code || called {
+			t.Errorf("%v: expected %d, got %d", storeErr, code, rec.Code)
+		}
+	}
+}
+
+func TestAuthentication_UnknownGuardPanics(t *testing.T) {
+	app := newAuthApp()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected an unknown guard to panic at construction")
+			}
+		}()
+		Authentication(app.Auth, "web", "admin")
+	}()
+
+	RegisterDefaults(app)
+	app.Route.Use("auth:admin")
+	defer func() {
+		r := recover()
+		if msg, _ := r.(string); !strings.Contains(msg, "route GET /dashboard") || !strings.Contains(msg, `"admin"`) {
+			t.Errorf("expected the route to panic with the unknown guard, got %v", r)
+		}
+	}()
+	app.Route.Get("/dashboard", protectedHandler(new(bool)))
+}
@@ -1 +1,62 @@
 package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+)
+
+type (
+	AuthorizeConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		Ability    string
+		// Arguments resolves the arguments for the gate, like the resource of the route.
+		Arguments func(ctx dojo.Context) ([]interface{}, error)
+	}
+)
+
+var (
+	DefaultAuthorizeConfig = AuthorizeConfig{
+		Skipper: DefaultSkipper,
+	}
+)
+
+func Authorize(ability string) dojo.MiddlewareFunc {
+	config := DefaultAuthorizeConfig
+	config.Ability = ability
+	return AuthorizeWithConfig(config)
+}
+
+func AuthorizeWithConfig(config AuthorizeConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultAuthorizeConfig.Skipper
+	}
+	if config.Ability == "" {
+		panic("dojo: authorize middleware requires an ability")
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			var args []interface{}
+			if config.Arguments != nil {
+				var err error
+				if args, err = config.Arguments(context); err != nil {
+					return err
+				}
+			}
+
+			if err := context.Authorize(config.Ability, args...); err != nil {
+				return err
+			}
+
+			return next(context)
+		}
+	}
+}
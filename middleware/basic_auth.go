@@ -0,0 +1,409 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/zengineDev/dojo"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	BasicAuthMode string
+
+	// BasicAuthValidator validates the credentials of the request
+	BasicAuthValidator func(username, password string, ctx dojo.Context) (bool, error)
+
+	// DigestHA1Provider returns the md5 of "username:realm:password" for the user
+	DigestHA1Provider func(username, realm string) (string, bool)
+
+	BasicAuthConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		Validator BasicAuthValidator
+
+		// CredentialsFile is a htpasswd file with "username:hash" lines, the hashes
+		// are argon2id or bcrypt. In digest mode it is a htdigest file with
+		// "username:realm:ha1" lines.
+		CredentialsFile string `yaml:"credentials_file"`
+
+		Realm string `yaml:"realm"`
+
+		Mode BasicAuthMode `yaml:"mode"`
+
+		// DigestHA1 is used in digest mode when no credentials file is set
+		DigestHA1 DigestHA1Provider
+
+		NonceLifetime time.Duration `yaml:"nonce_lifetime"`
+
+		ContextKey string `yaml:"context_key"`
+
+		// UserMapper loads the user of a guard with the basic driver, by
+		// default the username is passed as id to the user provider of the guard.
+		UserMapper func(ctx dojo.Context, username string) (dojo.Authenticable, error)
+	}
+
+	digestParams map[string]string
+
+	// digestCounts remembers the highest nonce count of the nonces, a request
+	// that repeats a count of its nonce is a replay.
+	digestCounts struct {
+		mu        sync.Mutex
+		lifetime  time.Duration
+		counts    map[string]digestCount
+		lastSweep time.Time
+	}
+
+	digestCount struct {
+		nc        uint64
+		expiresAt time.Time
+	}
+)
+
+const (
+	BasicMode  BasicAuthMode = "basic"
+	DigestMode BasicAuthMode = "digest"
+)
+
+var (
+	DefaultBasicAuthConfig = BasicAuthConfig{
+		Skipper:       DefaultSkipper,
+		Realm:         "Restricted",
+		Mode:          BasicMode,
+		NonceLifetime: 5 * time.Minute,
+		ContextKey:    "basic_auth_user",
+		UserMapper:    DefaultBasicAuthUserMapper,
+	}
+)
+
+func BasicAuth(validator BasicAuthValidator) dojo.MiddlewareFunc {
+	config := DefaultBasicAuthConfig
+	config.Validator = validator
+	return BasicAuthWithConfig(config)
+}
+
+func BasicAuthWithConfig(config BasicAuthConfig) dojo.MiddlewareFunc {
+	config = normalizeBasicAuthConfig(config)
+	if config.Mode == DigestMode {
+		return digestAuth(config)
+	}
+
+	challenge := fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, config.Realm)
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			username, password, ok := context.Request().BasicAuth()
+			if ok {
+				valid, err := config.Validator(username, password, context)
+				if err != nil {
+					return err
+				}
+				if valid {
+					context.Set(config.ContextKey, username)
+					return next(context)
+				}
+			}
+
+			context.Response().Header().Set(dojo.HeaderWWWAuthenticate, challenge)
+			return dojo.ErrUnauthorized
+		}
+	}
+}
+
+// BasicAuthenticator authenticates the requests of a guard with the basic
+// driver, the digest mode is not supported for guards.
+func BasicAuthenticator(config BasicAuthConfig) dojo.GuardAuthenticator {
+	config = normalizeBasicAuthConfig(config)
+	if config.Mode == DigestMode {
+		panic("dojo: the basic guard driver doesn't support the digest mode")
+	}
+	challenge := fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, config.Realm)
+
+	return func(ctx dojo.Context) (dojo.Authenticable, error) {
+		username, password, ok := ctx.Request().BasicAuth()
+		if ok {
+			valid, err := config.Validator(username, password, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if valid {
+				ctx.Set(config.ContextKey, username)
+				return config.UserMapper(ctx, username)
+			}
+		}
+		ctx.Response().Header().Set(dojo.HeaderWWWAuthenticate, challenge)
+		return nil, dojo.ErrUnauthorized
+	}
+}
+
+// DefaultBasicAuthUserMapper passes the username as id to the user provider of the guard of the request
+func DefaultBasicAuthUserMapper(ctx dojo.Context, username string) (dojo.Authenticable, error) {
+	users := ctx.Dojo().Auth.CurrentGuard(ctx).UserProvider()
+	if users == nil {
+		return nil, errors.New("dojo: the basic guard driver needs a user provider")
+	}
+	return users.RetrieveByID(ctx, username)
+}
+
+func normalizeBasicAuthConfig(config BasicAuthConfig) BasicAuthConfig {
+	if config.Skipper == nil {
+		config.Skipper = DefaultBasicAuthConfig.Skipper
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultBasicAuthConfig.Realm
+	}
+	if config.Mode == "" {
+		config.Mode = DefaultBasicAuthConfig.Mode
+	}
+	if config.NonceLifetime == 0 {
+		config.NonceLifetime = DefaultBasicAuthConfig.NonceLifetime
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = DefaultBasicAuthConfig.ContextKey
+	}
+	if config.UserMapper == nil {
+		config.UserMapper = DefaultBasicAuthConfig.UserMapper
+	}
+
+	var credentials map[string]string
+	if config.CredentialsFile != "" {
+		var err error
+		credentials, err = readCredentialsFile(config.CredentialsFile, config.Mode, config.Realm)
+		if err != nil {
+			panic(fmt.Sprintf("dojo: basic auth can't read the credentials file: %v", err))
+		}
+	}
+
+	if config.Mode == DigestMode {
+		if credentials != nil {
+			config.DigestHA1 = func(username, _ string) (string, bool) {
+				ha1, ok := credentials[username]
+				return ha1, ok
+			}
+		}
+		if config.DigestHA1 == nil {
+			panic("dojo: digest auth requires a credentials file or a DigestHA1 provider")
+		}
+		return config
+	}
+
+	if config.Validator == nil {
+		if credentials == nil {
+			panic("dojo: basic auth requires a validator or a credentials file")
+		}
+		// Unknown users are compared against a hash of the file, so they take
+		// as long as the known users and the usernames can't be enumerated.
+		var dummy string
+		for _, hash := range credentials {
+			dummy = hash
+			break
+		}
+		config.Validator = func(username, password string, ctx dojo.Context) (bool, error) {
+			hash, ok := credentials[username]
+			if !ok {
+				if dummy != "" {
+					_, _ = ctx.Dojo().Auth.ComparePasswordAndHash(password, dummy)
+				}
+				return false, nil
+			}
+			return ctx.Dojo().Auth.ComparePasswordAndHash(password, hash)
+		}
+	}
+	return config
+}
+
+func digestAuth(config BasicAuthConfig) dojo.MiddlewareFunc {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	opaque := hex.EncodeToString(secret[:8])
+	counts := &digestCounts{lifetime: config.NonceLifetime, counts: make(map[string]digestCount)}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			stale := false
+			req := context.Request()
+			if auth := req.Header.Get(dojo.HeaderAuthorization); strings.HasPrefix(auth, "Digest ") {
+				params := parseDigestParams(auth[len("Digest "):])
+				username := params["username"]
+				ha1, found := config.DigestHA1(username, config.Realm)
+				nonceValid, nonceExpired := checkDigestNonce(params["nonce"], secret, config.NonceLifetime)
+				stale = nonceExpired
+
+				if found && nonceValid && params["realm"] == config.Realm && params["uri"] == req.URL.RequestURI() && params["qop"] == "auth" {
+					ha2 := md5Hex(req.Method + ":" + params["uri"])
+					expected := md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+					if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) == 1 && counts.use(params["nonce"], params["nc"]) {
+						context.Set(config.ContextKey, username)
+						return next(context)
+					}
+				}
+			}
+
+			challenge := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=MD5, nonce=%q, opaque=%q`,
+				config.Realm, newDigestNonce(secret), opaque)
+			if stale {
+				challenge += ", stale=true"
+			}
+			context.Response().Header().Set(dojo.HeaderWWWAuthenticate, challenge)
+			return dojo.ErrUnauthorized
+		}
+	}
+}
+
+// use accepts the nonce count when it is higher than the counts the nonce was used with
+func (c *digestCounts) use(nonce, nc string) bool {
+	n, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil || n == 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+	if count, ok := c.counts[nonce]; ok && n <= count.nc {
+		return false
+	}
+	c.counts[nonce] = digestCount{nc: n, expiresAt: now.Add(c.lifetime)}
+	return true
+}
+
+// sweep removes the counts of the expired nonces once a minute
+func (c *digestCounts) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for nonce, count := range c.counts {
+		if now.After(count.expiresAt) {
+			delete(c.counts, nonce)
+		}
+	}
+}
+
+// newDigestNonce creates a stateless nonce from the current time and its hmac
+func newDigestNonce(secret []byte) string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(ts + ":" + nonceMAC(secret, ts)))
+}
+
+func checkDigestNonce(nonce string, secret []byte, lifetime time.Duration) (valid bool, expired bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		return false, false
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(nonceMAC(secret, parts[0]))) {
+		return false, false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false, false
+	}
+	if time.Since(time.Unix(ts, 0)) > lifetime {
+		return false, true
+	}
+	return true, false
+}
+
+func nonceMAC(secret []byte, ts string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseDigestParams(header string) digestParams {
+	params := digestParams{}
+	for _, part := range splitDigestHeader(header) {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
+
+// splitDigestHeader splits on the commas outside of quoted values
+func splitDigestHeader(header string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range header {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, header[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, header[start:])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func readCredentialsFile(path string, mode BasicAuthMode, realm string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if mode == DigestMode {
+			parts := strings.SplitN(line, ":", 3)
+			if len(parts) == 3 && parts[1] == realm {
+				credentials[parts[0]] = parts[2]
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			credentials[parts[0]] = parts[1]
+		}
+	}
+	return credentials, scanner.Err()
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/steinfletcher/apitest"
+	"github.com/zengineDev/dojo"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBasicAuth_CredentialsFile(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := ioutil.WriteFile(file, []byte("# users\nadmin:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	config := DefaultBasicAuthConfig
+	config.CredentialsFile = file
+	config.Realm = "Webhooks"
+	app.Route.Get("/hook", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, ctx.Value(config.ContextKey))
+	}, BasicAuthWithConfig(config))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/hook").
+		BasicAuth("admin", "s3cret").
+		Expect(t).
+		Body(`{"data":"admin"}`).
+		Status(http.StatusOK).
+		End()
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/hook").
+		BasicAuth("admin", "wrong").
+		Expect(t).
+		Header(dojo.HeaderWWWAuthenticate, `Basic realm="Webhooks", charset="UTF-8"`).
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+func TestBasicAuth_DigestMode(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	config := DefaultBasicAuthConfig
+	config.Mode = DigestMode
+	config.DigestHA1 = func(username, realm string) (string, bool) {
+		return md5Hex(username + ":" + realm + ":s3cret"), username == "admin"
+	}
+	app.Route.Get("/tools", func(ctx dojo.Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	}, BasicAuthWithConfig(config))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tools", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a challenge, got %d", rec.Code)
+	}
+	params := parseDigestParams(rec.Header().Get(dojo.HeaderWWWAuthenticate)[len("Digest "):])
+
+	ha1 := md5Hex("admin:" + config.Realm + ":s3cret")
+	ha2 := md5Hex("GET:/tools")
+	response := md5Hex(ha1 + ":" + params["nonce"] + ":00000001:abc:auth:" + ha2)
+	authorization := fmt.Sprintf(`Digest username="admin", realm=%q, nonce=%q, uri="/tools", qop=auth, nc=00000001, cnonce="abc", response=%q, opaque=%q`,
+		config.Realm, params["nonce"], response, params["opaque"])
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/tools").
+		Header(dojo.HeaderAuthorization, authorization).
+		Expect(t).
+		Status(http.StatusNoContent).
+		End()
+
+	// The same nonce count is a replay
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/tools").
+		Header(dojo.HeaderAuthorization, authorization).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	response = md5Hex(ha1 + ":" + params["nonce"] + ":00000002:abc:auth:" + ha2)
+	authorization = fmt.Sprintf(`Digest username="admin", realm=%q, nonce=%q, uri="/tools", qop=auth, nc=00000002, cnonce="abc", response=%q, opaque=%q`,
+		config.Realm, params["nonce"], response, params["opaque"])
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/tools").
+		Header(dojo.HeaderAuthorization, authorization).
+		Expect(t).
+		Status(http.StatusNoContent).
+		End()
+}
+
+func TestBasicAuth_UnknownUsersAreCompared(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost+4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := ioutil.WriteFile(file, []byte("admin:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := dojo.New(dojo.DefaultConfiguration{})
+	config := DefaultBasicAuthConfig
+	config.CredentialsFile = file
+	config = normalizeBasicAuthConfig(config)
+	ctx := app.NewContext(dojo.RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	start := time.Now()
+	if ok, err := config.Validator("nobody", "s3cret", ctx); ok || err != nil {
+		t.Fatalf("expected unknown users to be rejected, got %v %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond {
+		t.Errorf("expected the password of unknown users to be hashed, took %s", elapsed)
+	}
+}
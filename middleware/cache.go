@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	CacheConfig struct {
+		Skipper Skipper
+
+		// TTL is how long a response is cached
+		TTL time.Duration `yaml:"ttl"`
+
+		// Tags are added to every cached response, handlers add more with AddCacheTags
+		Tags []string `yaml:"tags"`
+
+		// VaryHeaders are the request headers that are part of the cache key
+		VaryHeaders []string `yaml:"vary_headers"`
+
+		// KeyFunc returns the cache key of a request, the default is the
+		// method, the host and the uri.
+		KeyFunc func(ctx dojo.Context) string `yaml:"-"`
+
+		// Store keeps the responses, it defaults to a memory store. Use
+		// NewRedisResponseCacheStore to share the cache between instances.
+		Store ResponseCacheStore `yaml:"-"`
+	}
+)
+
+const cacheTagsKey = "cache_tags"
+
+// perRequestHeaders belong to a single response and are never replayed from the cache
+var perRequestHeaders = []string{
+	dojo.HeaderSetCookie,
+	dojo.HeaderXRequestID,
+	dojo.HeaderContentSecurityPolicy,
+	dojo.HeaderContentSecurityPolicyReportOnly,
+	dojo.HeaderRateLimitLimit,
+	dojo.HeaderRateLimitRemaining,
+	dojo.HeaderRateLimitReset,
+	dojo.HeaderRetryAfter,
+}
+
+var (
+	DefaultCacheConfig = CacheConfig{
+		Skipper:     skipUserRequests,
+		TTL:         time.Minute,
+		VaryHeaders: []string{dojo.HeaderAccept, dojo.HeaderAcceptEncoding},
+	}
+)
+
+// Cache caches the full GET responses for the ttl
+func Cache(ttl time.Duration) dojo.MiddlewareFunc {
+	config := DefaultCacheConfig
+	config.TTL = ttl
+	return CacheWithConfig(config)
+}
+
+func CacheWithConfig(config CacheConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCacheConfig.Skipper
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultCacheConfig.TTL
+	}
+	if config.VaryHeaders == nil {
+		config.VaryHeaders = DefaultCacheConfig.VaryHeaders
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = defaultCacheKey
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryResponseCacheStore()
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			req := context.Request()
+			if config.Skipper(context) || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+				return next(context)
+			}
+
+			key := config.KeyFunc(context)
+			for _, h := range config.VaryHeaders {
+				key += "|" + req.Header.Get(h)
+			}
+
+			res := context.Response()
+			cached, err := config.Store.Get(context, key)
+			if err != nil {
+				context.Logger().WithError(err).Error("response cache store failed")
+			}
+			if cached != nil {
+				header := res.Header()
+				for k, v := range cached.Header {
+					header[k] = v
+				}
+				res.WriteHeader(cached.Status)
+				if req.Method != http.MethodHead {
+					_, err = res.Write(cached.Body)
+				}
+				return err
+			}
+
+			// The headers of the outer middlewares are set again on every request
+			before := res.Header().Clone()
+			rec := newBodyRecorder(res.Writer)
+			res.Writer = rec
+			err = next(context)
+			res.Writer = rec.ResponseWriter
+			if rec.streaming || (!rec.wroteHeader && rec.body.Len() == 0) {
+				return err
+			}
+
+			if err == nil && req.Method == http.MethodGet && cacheable(rec.status, res.Header()) {
+				entry := &CachedResponse{
+					Status: rec.status,
+					Header: handlerHeaders(before, res.Header()),
+					Body:   append([]byte(nil), rec.body.Bytes()...),
+				}
+				tags := append(append([]string(nil), config.Tags...), cacheTags(context)...)
+				if serr := config.Store.Set(context, key, entry, config.TTL, tags); serr != nil {
+					context.Logger().WithError(serr).Error("response cache store failed")
+				}
+			}
+
+			rec.writeTo(rec.ResponseWriter)
+			return err
+		}
+	}
+}
+
+// AddCacheTags tags the response of the request, the cached response is
+// removed when one of its tags is invalidated.
+func AddCacheTags(ctx dojo.Context, tags ...string) {
+	ctx.Set(cacheTagsKey, append(cacheTags(ctx), tags...))
+}
+
+func cacheTags(ctx dojo.Context) []string {
+	tags, _ := ctx.Value(cacheTagsKey).([]string)
+	return tags
+}
+
+// cacheable only accepts successful public responses that don't set cookies
+func cacheable(status int, header http.Header) bool {
+	if status != http.StatusOK || header.Get(dojo.HeaderSetCookie) != "" {
+		return false
+	}
+	cc := strings.ToLower(header.Get(dojo.HeaderCacheControl))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// handlerHeaders returns the headers the handler added or changed
+func handlerHeaders(before, after http.Header) http.Header {
+	header := make(http.Header)
+	for k, v := range after {
+		if !equalValues(before[k], v) {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+	for _, k := range perRequestHeaders {
+		header.Del(k)
+	}
+	return header
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func defaultCacheKey(ctx dojo.Context) string {
+	req := ctx.Request()
+	return req.Host + req.URL.RequestURI()
+}
+
+// skipUserRequests doesn't cache the requests with credentials, a session or
+// an authenticated user, their responses belong to a single user.
+func skipUserRequests(ctx dojo.Context) bool {
+	req := ctx.Request()
+	if req.Header.Get(dojo.HeaderAuthorization) != "" {
+		return true
+	}
+	if _, err := req.Cookie(ctx.Dojo().Configuration.Session.Name); err == nil {
+		return true
+	}
+	user := ctx.Dojo().Auth.GetAuthUser(ctx)
+	return !user.IsGuest()
+}
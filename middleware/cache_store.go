@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// ResponseCacheStore keeps the responses of the cache middleware
+	ResponseCacheStore interface {
+		// Get returns nil when the key is not cached
+		Get(ctx context.Context, key string) (*CachedResponse, error)
+		Set(ctx context.Context, key string, res *CachedResponse, ttl time.Duration, tags []string) error
+		// InvalidateTags removes the responses with one of the tags
+		InvalidateTags(ctx context.Context, tags ...string) error
+	}
+
+	CachedResponse struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body"`
+	}
+)
+
+const defaultMemoryCacheMaxEntries = 10000
+
+type memoryCacheEntry struct {
+	res       *CachedResponse
+	expiresAt time.Time
+	tags      []string
+}
+
+// MemoryResponseCacheStore keeps the responses in the memory of the process.
+// The expired responses are removed once a minute, the response that expires
+// first makes room when MaxEntries are cached.
+type MemoryResponseCacheStore struct {
+	MaxEntries int
+
+	mu        sync.Mutex
+	entries   map[string]memoryCacheEntry
+	tags      map[string]map[string]struct{}
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryResponseCacheStore() *MemoryResponseCacheStore {
+	return &MemoryResponseCacheStore{
+		MaxEntries: defaultMemoryCacheMaxEntries,
+		entries:    make(map[string]memoryCacheEntry),
+		tags:       make(map[string]map[string]struct{}),
+		now:        time.Now,
+	}
+}
+
+func (s *MemoryResponseCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if now.After(e.expiresAt) {
+		s.remove(key)
+		return nil, nil
+	}
+	return e.res, nil
+}
+
+func (s *MemoryResponseCacheStore) Set(_ context.Context, key string, res *CachedResponse, ttl time.Duration, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	s.remove(key)
+	if s.MaxEntries > 0 && len(s.entries) >= s.MaxEntries {
+		s.lastSweep = time.Time{}
+		s.sweep(now)
+	}
+	if s.MaxEntries > 0 && len(s.entries) >= s.MaxEntries {
+		s.evict()
+	}
+
+	s.entries[key] = memoryCacheEntry{res: res, expiresAt: now.Add(ttl), tags: tags}
+	for _, tag := range tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+func (s *MemoryResponseCacheStore) InvalidateTags(_ context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			s.remove(key)
+		}
+	}
+	return nil
+}
+
+// remove deletes the response and takes it out of the sets of its tags
+func (s *MemoryResponseCacheStore) remove(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	for _, tag := range e.tags {
+		delete(s.tags[tag], key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// evict removes the response that expires first
+func (s *MemoryResponseCacheStore) evict() {
+	var oldest string
+	var expiresAt time.Time
+	for key, e := range s.entries {
+		if oldest == "" || e.expiresAt.Before(expiresAt) {
+			oldest, expiresAt = key, e.expiresAt
+		}
+	}
+	s.remove(oldest)
+}
+
+// sweep removes the expired responses once a minute
+func (s *MemoryResponseCacheStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if now.After(e.expiresAt) {
+			s.remove(key)
+		}
+	}
+}
+
+// RedisResponseCacheStore keeps the responses in redis so they are shared between instances.
+type RedisResponseCacheStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func NewRedisResponseCacheStore(client *redis.Client) *RedisResponseCacheStore {
+	return &RedisResponseCacheStore{Client: client, Prefix: "dojo:cache:"}
+}
+
+func (s *RedisResponseCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	b, err := s.Client.Get(ctx, s.Prefix+"response:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res CachedResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// cacheSetScript stores the response and adds it to its tags in one step, a
+// tag lives as long as its longest cached response.
+var cacheSetScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], ARGV[3])
+	if redis.call('PTTL', KEYS[i]) < ttl then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+func (s *RedisResponseCacheStore) Set(ctx context.Context, key string, res *CachedResponse, ttl time.Duration, tags []string) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.Prefix + "response:" + key}
+	for _, tag := range tags {
+		keys = append(keys, s.Prefix+"tag:"+tag)
+	}
+	return cacheSetScript.Run(ctx, s.Client, keys, b, ttl.Milliseconds(), key).Err()
+}
+
+func (s *RedisResponseCacheStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		tagKey := s.Prefix + "tag:" + tag
+		keys, err := s.Client.SMembers(ctx, tagKey).Result()
+		if err != nil {
+			return err
+		}
+		del := []string{tagKey}
+		for _, key := range keys {
+			del = append(del, s.Prefix+"response:"+key)
+		}
+		if err := s.Client.Del(ctx, del...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
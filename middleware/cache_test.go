@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	hook := test.NewLocal(app.Logger)
+	app.Route.Get("/posts", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, "posts")
+	}, Logging(), ETagWithConfig(ETagConfig{Weak: true}))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/posts", nil))
+	etag := rec.Header().Get(dojo.HeaderETag)
+	if rec.Code != http.StatusOK || len(etag) < 4 || etag[:2] != "W/" {
+		t.Fatalf("expected a weak etag, got %d %q", rec.Code, etag)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Data["status"] != http.StatusOK || entry.Data["bytes_out"] != int64(rec.Body.Len()) {
+		t.Errorf("expected the logged status 200 with the bytes of the body, got %v", entry)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	req.Header.Set(dojo.HeaderIfNoneMatch, `"other", `+etag)
+	rec = httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("expected 304 without body, got %d %q", rec.Code, rec.Body.String())
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Data["status"] != http.StatusNotModified || entry.Data["bytes_out"] != int64(0) {
+		t.Errorf("expected the logged status 304 without bytes, got %v", entry)
+	}
+}
+
+func TestContext_NotModifiedSince(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	app.Route.Get("/post", func(ctx dojo.Context) error {
+		ctx.CacheControl("public", "max-age=60")
+		if ctx.NotModifiedSince(updated) {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+		return ctx.JSON(http.StatusOK, "post")
+	})
+
+	cases := map[string]int{
+		updated.Format(http.TimeFormat):                 http.StatusNotModified,
+		updated.Add(-time.Hour).Format(http.TimeFormat): http.StatusOK,
+		"": http.StatusOK,
+	}
+	for since, code := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/post", nil)
+		if since != "" {
+			req.Header.Set(dojo.HeaderIfModifiedSince, since)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("%q: expected %d, got %d", since, code, rec.Code)
+		}
+		if rec.Header().Get(dojo.HeaderLastModified) != updated.Format(http.TimeFormat) || rec.Header().Get(dojo.HeaderCacheControl) != "public, max-age=60" {
+			t.Errorf("%q: expected the cache headers, got %v", since, rec.Header())
+		}
+	}
+}
+
+func TestCache_Tags(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	store := NewMemoryResponseCacheStore()
+	calls := 0
+	app.Route.Get("/posts/{id}", func(ctx dojo.Context) error {
+		calls++
+		AddCacheTags(ctx, "post:"+ctx.Param("id"))
+		return ctx.JSON(http.StatusOK, calls)
+	}, CacheWithConfig(CacheConfig{TTL: time.Minute, Tags: []string{"posts"}, Store: store}))
+
+	get := func(header string) string {
+		req := httptest.NewRequest(http.MethodGet, "/posts/1", nil)
+		if header != "" {
+			req.Header.Set(dojo.HeaderAuthorization, header)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	if first, second := get(""), get(""); first != `{"data":1}` || second != first {
+		t.Fatalf("expected the cached response, got %s and %s", first, second)
+	}
+	if body := get("Bearer token"); body != `{"data":2}` {
+		t.Fatalf("expected authorized requests to skip the cache, got %s", body)
+	}
+
+	if err := store.InvalidateTags(context.Background(), "post:1"); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(""); body != `{"data":3}` {
+		t.Errorf("expected the invalidated response to be rendered again, got %s", body)
+	}
+	if body := get(""); body != `{"data":3}` {
+		t.Errorf("expected the new response to be cached, got %s", body)
+	}
+}
+
+func TestCache_SkipsSessionUsers(t *testing.T) {
+	app := newAuthApp()
+	app.Route.Get("/me", func(ctx dojo.Context) error {
+		user := ctx.Dojo().Auth.GetAuthUser(ctx)
+		return ctx.JSON(http.StatusOK, user.GetAuthID().String())
+	}, Authentication(app.Auth), Cache(time.Minute))
+
+	me := func(cookies []*http.Cookie) string {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	login := func() []*http.Cookie {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login-as", nil))
+		return rec.Result().Cookies()
+	}
+
+	alice, bob := me(login()), me(login())
+	if alice == bob {
+		t.Fatalf("expected every user to get their own response, both got %s", alice)
+	}
+}
+
+func TestCache_DoesNotReplayPerRequestHeaders(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.Route.Get("/posts", func(ctx dojo.Context) error {
+		ctx.Response().Header().Set("X-Handler", "posts")
+		return ctx.JSON(http.StatusOK, "posts")
+	}, RequestID(), Cache(time.Minute))
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/posts", nil))
+		return rec
+	}
+
+	first, second := get(), get()
+	if second.Header().Get("X-Handler") != "posts" {
+		t.Errorf("expected the handler headers to be cached, got %v", second.Header())
+	}
+	if id := second.Header().Get(dojo.HeaderXRequestID); id == "" || id == first.Header().Get(dojo.HeaderXRequestID) {
+		t.Errorf("expected a new request id for the cached response, got %q", id)
+	}
+	if values := second.Header().Values(dojo.HeaderXRequestID); len(values) != 1 {
+		t.Errorf("expected a single request id, got %v", values)
+	}
+}
+
+func TestMemoryResponseCacheStore_Bounds(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryResponseCacheStore()
+	store.MaxEntries = 2
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+	res := &CachedResponse{Status: http.StatusOK}
+
+	_ = store.Set(ctx, "a", res, time.Minute, []string{"posts"})
+	_ = store.Set(ctx, "b", res, 2*time.Minute, []string{"posts"})
+	_ = store.Set(ctx, "c", res, 3*time.Minute, []string{"users"})
+	if len(store.entries) != 2 {
+		t.Fatalf("expected at most 2 entries, got %d", len(store.entries))
+	}
+	if cached, _ := store.Get(ctx, "a"); cached != nil {
+		t.Error("expected the entry that expires first to be evicted")
+	}
+	if _, ok := store.tags["posts"]["a"]; ok {
+		t.Error("expected the evicted entry to be removed from its tags")
+	}
+
+	// The expired entries and their tags are swept
+	now = now.Add(5 * time.Minute)
+	if cached, _ := store.Get(ctx, "c"); cached != nil {
+		t.Error("expected the entry to be expired")
+	}
+	if len(store.entries) != 0 || len(store.tags) != 0 {
+		t.Errorf("expected the expired entries and tags to be removed, got %d entries and %d tags", len(store.entries), len(store.tags))
+	}
+}
+
+func TestRedisResponseCacheStore_Set(t *testing.T) {
+	addr := os.Getenv("DOJO_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("DOJO_TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	store := NewRedisResponseCacheStore(client)
+	store.Prefix = fmt.Sprintf("dojo:test:%d:", time.Now().UnixNano())
+	ctx := context.Background()
+	defer func() {
+		keys, _ := client.Keys(ctx, store.Prefix+"*").Result()
+		if len(keys) > 0 {
+			client.Del(ctx, keys...)
+		}
+	}()
+
+	if err := store.Set(ctx, "a", &CachedResponse{Status: http.StatusOK, Body: []byte("a")}, time.Minute, []string{"posts"}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := client.PTTL(ctx, store.Prefix+"tag:posts").Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the tag to expire with the response, got %s", ttl)
+	}
+	if cached, err := store.Get(ctx, "a"); err != nil || cached == nil || string(cached.Body) != "a" {
+		t.Fatalf("expected the cached response, got %v %v", cached, err)
+	}
+	if err := store.InvalidateTags(ctx, "posts"); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := store.Get(ctx, "a"); cached != nil {
+		t.Error("expected the response to be invalidated")
+	}
+}
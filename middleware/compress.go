@@ -0,0 +1,314 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zengineDev/dojo"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	CompressConfig struct {
+		Skipper Skipper
+
+		// Encodings are the supported encodings, the first is preferred when
+		// the client accepts several with the same q-value.
+		Encodings []string `yaml:"encodings"`
+
+		GzipLevel int `yaml:"gzip_level"`
+
+		BrotliLevel int `yaml:"brotli_level"`
+
+		ZstdLevel int `yaml:"zstd_level"`
+
+		// MinLength is the size in bytes a response needs to be compressed
+		MinLength int `yaml:"min_length"`
+
+		// ExcludedContentTypes are not compressed, a type ending with "/" matches
+		// all its subtypes like "image/".
+		ExcludedContentTypes []string `yaml:"excluded_content_types"`
+	}
+
+	// compressWriter buffers the response until it knows if it is worth
+	// compressing, then it writes through the encoder or the original writer.
+	compressWriter struct {
+		http.ResponseWriter
+		config   *CompressConfig
+		encoding string
+		pool     *sync.Pool
+		encoder  compressEncoder
+		status   int
+		buf      []byte
+		decided  bool
+	}
+
+	compressEncoder interface {
+		io.WriteCloser
+		Flush() error
+		Reset(w io.Writer)
+	}
+)
+
+var (
+	DefaultCompressConfig = CompressConfig{
+		Skipper:     DefaultSkipper,
+		Encodings:   []string{"br", "zstd", "gzip"},
+		GzipLevel:   gzip.DefaultCompression,
+		BrotliLevel: 4,
+		ZstdLevel:   int(zstd.SpeedDefault),
+		MinLength:   1024,
+		ExcludedContentTypes: []string{
+			"image/",
+			"video/",
+			"audio/",
+			"font/woff",
+			"font/woff2",
+			"application/zip",
+			"application/gzip",
+			"application/x-gzip",
+			"application/zstd",
+			"application/octet-stream",
+			"application/pdf",
+		},
+	}
+
+	errCompressHijackNotSupported = errors.New("the response writer does not support hijacking")
+)
+
+func Compress() dojo.MiddlewareFunc {
+	config := DefaultCompressConfig
+	return CompressWithConfig(config)
+}
+
+func CompressWithConfig(config CompressConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCompressConfig.Skipper
+	}
+	if len(config.Encodings) == 0 {
+		config.Encodings = DefaultCompressConfig.Encodings
+	}
+	if config.GzipLevel == 0 {
+		config.GzipLevel = DefaultCompressConfig.GzipLevel
+	}
+	if config.BrotliLevel == 0 {
+		config.BrotliLevel = DefaultCompressConfig.BrotliLevel
+	}
+	if config.ZstdLevel == 0 {
+		config.ZstdLevel = DefaultCompressConfig.ZstdLevel
+	}
+	if config.MinLength == 0 {
+		config.MinLength = DefaultCompressConfig.MinLength
+	}
+	if config.ExcludedContentTypes == nil {
+		config.ExcludedContentTypes = DefaultCompressConfig.ExcludedContentTypes
+	}
+
+	pools := make(map[string]*sync.Pool)
+	for _, enc := range config.Encodings {
+		pools[enc] = newEncoderPool(enc, config)
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			res := context.Response()
+			res.Header().Add(dojo.HeaderVary, dojo.HeaderAcceptEncoding)
+
+			accepted := dojo.AcceptedEncodings(context.Request().Header.Get(dojo.HeaderAcceptEncoding), config.Encodings...)
+			if len(accepted) == 0 || context.Request().Method == http.MethodHead {
+				return next(context)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: res.Writer,
+				config:         &config,
+				encoding:       accepted[0],
+				pool:           pools[accepted[0]],
+				status:         http.StatusOK,
+			}
+			res.Writer = cw
+			defer func() {
+				cw.close()
+				res.Writer = cw.ResponseWriter
+			}()
+
+			return next(context)
+		}
+	}
+}
+
+func newEncoderPool(encoding string, config CompressConfig) *sync.Pool {
+	switch encoding {
+	case "br":
+		return &sync.Pool{New: func() interface{} {
+			return brotli.NewWriterLevel(io.Discard, config.BrotliLevel)
+		}}
+	case "zstd":
+		return &sync.Pool{New: func() interface{} {
+			enc, err := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(zstd.EncoderLevel(config.ZstdLevel)),
+				zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				panic(err)
+			}
+			return enc
+		}}
+	case "gzip":
+		return &sync.Pool{New: func() interface{} {
+			w, err := gzip.NewWriterLevel(io.Discard, config.GzipLevel)
+			if err != nil {
+				panic(err)
+			}
+			return w
+		}}
+	default:
+		panic("dojo: unsupported compression " + encoding)
+	}
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	// The status is sent once it is known if the response gets compressed
+	w.status = code
+	if !bodyAllowedForStatus(code) {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.config.MinLength {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush sends what is buffered, a streamed response is compressed no matter its size
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errCompressHijackNotSupported
+	}
+	w.decided = true
+	return h.Hijack()
+}
+
+func (w *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// start decides about the compression and writes the buffered body
+func (w *compressWriter) start(large bool) error {
+	w.decide(large)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) decide(large bool) {
+	w.decided = true
+	header := w.Header()
+
+	if header.Get(dojo.HeaderContentType) == "" && len(w.buf) > 0 {
+		// The type has to be sniffed from the uncompressed body
+		header.Set(dojo.HeaderContentType, http.DetectContentType(w.buf))
+	}
+
+	if large && bodyAllowedForStatus(w.status) && header.Get(dojo.HeaderContentEncoding) == "" &&
+		!w.excluded(header.Get(dojo.HeaderContentType)) {
+		header.Set(dojo.HeaderContentEncoding, w.encoding)
+		header.Del(dojo.HeaderContentLength)
+		// The encoded body is another representation, it can't share a strong etag
+		if etag := header.Get(dojo.HeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set(dojo.HeaderETag, "W/"+etag)
+		}
+		w.encoder = w.pool.Get().(compressEncoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressWriter) excluded(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range w.config.ExcludedContentTypes {
+		if ct == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// close writes small responses uncompressed and finishes the encoder
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == http.StatusOK && len(w.buf) == 0 {
+			// Nothing was written, the error handler still can write the response
+			return
+		}
+		_ = w.start(false)
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		w.pool.Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
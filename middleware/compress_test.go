@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zengineDev/dojo"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompress_Negotiation(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	large := strings.Repeat("compress me ", 200)
+	app.Route.Get("/large", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, large)
+	}, Compress())
+	app.Route.Get("/small", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, "tiny")
+	}, Compress())
+	app.Route.Get("/image", func(ctx dojo.Context) error {
+		ctx.Response().Header().Set(dojo.HeaderContentType, "image/png")
+		_, err := ctx.Response().Write([]byte(large))
+		return err
+	}, Compress())
+
+	cases := []struct {
+		path     string
+		accept   string
+		encoding string
+	}{
+		{"/large", "gzip, deflate, br", "br"},
+		{"/large", "gzip;q=1.0, br;q=0.5", "gzip"},
+		{"/large", "zstd, gzip;q=0.8", "zstd"},
+		{"/large", "br;q=0, *", "zstd"},
+		{"/large", "identity", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set(dojo.HeaderAcceptEncoding, c.accept)
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+
+		if got := rec.Header().Get(dojo.HeaderContentEncoding); got != c.encoding {
+			t.Errorf("%s %q: expected the encoding %q, got %q", c.path, c.accept, c.encoding, got)
+			continue
+		}
+		if rec.Header().Get(dojo.HeaderVary) != dojo.HeaderAcceptEncoding {
+			t.Errorf("%s %q: expected Vary: Accept-Encoding", c.path, c.accept)
+		}
+		body := decompress(t, c.encoding, rec.Body.Bytes())
+		if c.path == "/large" && !strings.Contains(body, large) {
+			t.Errorf("%s %q: unexpected body %.40s", c.path, c.accept, body)
+		}
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.Route.Get("/events", func(ctx dojo.Context) error {
+		res := ctx.Response()
+		res.Header().Set(dojo.HeaderContentType, "text/event-stream")
+		res.Write([]byte("data: one\n\n"))
+		res.Flush()
+		res.Write([]byte("data: two\n\n"))
+		return nil
+	}, Compress())
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set(dojo.HeaderAcceptEncoding, "gzip")
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+
+	if !rec.Flushed || rec.Header().Get(dojo.HeaderContentEncoding) != "gzip" {
+		t.Fatalf("expected a flushed gzip stream, got %v %q", rec.Flushed, rec.Header().Get(dojo.HeaderContentEncoding))
+	}
+	if body := decompress(t, "gzip", rec.Body.Bytes()); body != "data: one\n\ndata: two\n\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestCompress_WeakensTheETag(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	large := strings.Repeat("compress me ", 200)
+	app.Route.Get("/large", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, large)
+	}, Compress(), ETag())
+
+	get := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/large", nil)
+		req.Header.Set(dojo.HeaderAcceptEncoding, accept)
+		req.Header.Set(dojo.HeaderIfNoneMatch, ifNoneMatch)
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := get("", "").Header().Get(dojo.HeaderETag)
+	if plain == "" || strings.HasPrefix(plain, "W/") {
+		t.Fatalf("expected a strong etag without compression, got %q", plain)
+	}
+	encoded := get("gzip", "").Header().Get(dojo.HeaderETag)
+	if encoded != "W/"+plain {
+		t.Fatalf("expected the weak etag %q for the gzip body, got %q", "W/"+plain, encoded)
+	}
+	if rec := get("gzip", encoded); rec.Code != http.StatusNotModified {
+		t.Errorf("expected the weak etag to match, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	CORSConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		// AllowOrigins are exact origins like "https://example.com", "*" for
+		// every origin or wildcard subdomains like "https://*.example.com".
+		AllowOrigins []string `yaml:"allow_origins"`
+
+		// AllowOriginPatterns are regular expressions the origin has to match
+		AllowOriginPatterns []string `yaml:"allow_origin_patterns"`
+
+		// AllowOriginFunc decides about the origins that are not allowed by the lists
+		AllowOriginFunc func(origin string) bool `yaml:"-"`
+
+		AllowMethods []string `yaml:"allow_methods"`
+
+		// AllowHeaders are the request headers of the preflight response, the
+		// requested headers are allowed when it is empty.
+		AllowHeaders []string `yaml:"allow_headers"`
+
+		AllowCredentials bool `yaml:"allow_credentials"`
+
+		ExposeHeaders []string `yaml:"expose_headers"`
+
+		// MaxAge in seconds the preflight response may be cached
+		MaxAge int `yaml:"max_age"`
+	}
+)
+
+var (
+	DefaultCORSConfig = CORSConfig{
+		Skipper:      DefaultSkipper,
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
+	}
+)
+
+func CORS() dojo.MiddlewareFunc {
+	config := DefaultCORSConfig
+	return CORSWithConfig(config)
+}
+
+func CORSWithConfig(config CORSConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCORSConfig.Skipper
+	}
+	if len(config.AllowOrigins) == 0 && len(config.AllowOriginPatterns) == 0 && config.AllowOriginFunc == nil {
+		config.AllowOrigins = DefaultCORSConfig.AllowOrigins
+	}
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = DefaultCORSConfig.AllowMethods
+	}
+
+	allowAll := false
+	exact := make(map[string]bool)
+	var patterns []*regexp.Regexp
+	for _, origin := range config.AllowOrigins {
+		origin = strings.ToLower(strings.TrimRight(origin, "/"))
+		switch {
+		case origin == "*":
+			allowAll = true
+		case strings.Contains(origin, "*"):
+			patterns = append(patterns, wildcardOrigin(origin))
+		default:
+			exact[origin] = true
+		}
+	}
+	for _, pattern := range config.AllowOriginPatterns {
+		patterns = append(patterns, regexp.MustCompile(pattern))
+	}
+
+	allowMethods := strings.Join(config.AllowMethods, ",")
+	allowHeaders := strings.Join(config.AllowHeaders, ",")
+	exposeHeaders := strings.Join(config.ExposeHeaders, ",")
+	maxAge := strconv.Itoa(config.MaxAge)
+
+	// Every origin gets the same answer when all are allowed without credentials
+	anyOrigin := allowAll && !config.AllowCredentials
+
+	allowed := func(origin string) bool {
+		if allowAll || exact[strings.ToLower(origin)] {
+			return true
+		}
+		for _, re := range patterns {
+			if re.MatchString(origin) {
+				return true
+			}
+		}
+		return config.AllowOriginFunc != nil && config.AllowOriginFunc(origin)
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			req := context.Request()
+			header := context.Response().Header()
+			origin := req.Header.Get(dojo.HeaderOrigin)
+			preflight := req.Method == http.MethodOptions && req.Header.Get(dojo.HeaderAccessControlRequestMethod) != ""
+
+			if !anyOrigin {
+				header.Add(dojo.HeaderVary, dojo.HeaderOrigin)
+			}
+
+			if origin == "" || !allowed(origin) {
+				if preflight {
+					return context.NoContent(http.StatusNoContent)
+				}
+				return next(context)
+			}
+
+			if anyOrigin {
+				header.Set(dojo.HeaderAccessControlAllowOrigin, "*")
+			} else {
+				header.Set(dojo.HeaderAccessControlAllowOrigin, origin)
+			}
+			if config.AllowCredentials {
+				header.Set(dojo.HeaderAccessControlAllowCredentials, "true")
+			}
+
+			if !preflight {
+				setHeader(header, dojo.HeaderAccessControlExposeHeaders, exposeHeaders)
+				return next(context)
+			}
+
+			header.Add(dojo.HeaderVary, dojo.HeaderAccessControlRequestMethod)
+			header.Add(dojo.HeaderVary, dojo.HeaderAccessControlRequestHeaders)
+			header.Set(dojo.HeaderAccessControlAllowMethods, allowMethods)
+			if allowHeaders != "" {
+				header.Set(dojo.HeaderAccessControlAllowHeaders, allowHeaders)
+			} else {
+				setHeader(header, dojo.HeaderAccessControlAllowHeaders, req.Header.Get(dojo.HeaderAccessControlRequestHeaders))
+			}
+			if config.MaxAge > 0 {
+				header.Set(dojo.HeaderAccessControlMaxAge, maxAge)
+			}
+			return context.NoContent(http.StatusNoContent)
+		}
+	}
+}
+
+// wildcardOrigin turns "https://*.example.com" into a regexp that matches
+// the subdomains of example.com, but not example.com itself.
+func wildcardOrigin(origin string) *regexp.Regexp {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(origin), `\*`, `[a-z0-9-]+(\.[a-z0-9-]+)*`)
+	return regexp.MustCompile("(?i)^" + pattern + "$")
+}
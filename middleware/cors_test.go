@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestCORS_Origins(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	config := CORSConfig{
+		AllowOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowOriginPatterns: []string{`^https://review-\d+\.example\.net$`},
+		AllowOriginFunc: func(origin string) bool {
+			return origin == "https://partner.test"
+		},
+		AllowCredentials: true,
+		ExposeHeaders:    []string{"X-Total"},
+	}
+	app.Route.Get("/users", func(ctx dojo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}, CORSWithConfig(config))
+
+	cases := map[string]bool{
+		"https://example.com":           true,
+		"https://api.example.org":       true,
+		"https://example.org":           false,
+		"https://review-12.example.net": true,
+		"https://partner.test":          true,
+		"https://evil.test":             false,
+	}
+	for origin, ok := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set(dojo.HeaderOrigin, origin)
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+
+		got := rec.Header().Get(dojo.HeaderAccessControlAllowOrigin)
+		if ok && (got != origin || rec.Header().Get(dojo.HeaderAccessControlExposeHeaders) != "X-Total") {
+			t.Errorf("expected %s to be allowed, got %q", origin, got)
+		}
+		if !ok && got != "" {
+			t.Errorf("expected %s to be rejected, got %q", origin, got)
+		}
+		if rec.Header().Get(dojo.HeaderVary) != dojo.HeaderOrigin {
+			t.Errorf("expected Vary: Origin for %s", origin)
+		}
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = func(err error, ctx dojo.Context) { t.Fatal(err) }
+	config := DefaultCORSConfig
+	config.MaxAge = 600
+	app.MiddlewareRegistry.Register("cors", CORSWithConfig(config))
+	app.Route.Use("cors")
+	called := false
+	app.Route.Put("/users/{id}", protectedHandler(&called))
+
+	req := httptest.NewRequest(http.MethodOptions, "/users/1", nil)
+	req.Header.Set(dojo.HeaderOrigin, "https://example.com")
+	req.Header.Set(dojo.HeaderAccessControlRequestMethod, http.MethodPut)
+	req.Header.Set(dojo.HeaderAccessControlRequestHeaders, "Content-Type")
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent || called {
+		t.Fatalf("expected the preflight to be answered, got %d", rec.Code)
+	}
+	expect := map[string]string{
+		dojo.HeaderAccessControlAllowOrigin:  "*",
+		dojo.HeaderAccessControlAllowHeaders: "Content-Type",
+		dojo.HeaderAccessControlMaxAge:       "600",
+	}
+	for name, value := range expect {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, got)
+		}
+	}
+	if !regexp.MustCompile(`PUT`).MatchString(rec.Header().Get(dojo.HeaderAccessControlAllowMethods)) {
+		t.Errorf("expected PUT to be allowed, got %q", rec.Header().Get(dojo.HeaderAccessControlAllowMethods))
+	}
+}
+
+func TestRouter_AutomaticOptions(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	handler := func(ctx dojo.Context) error { return ctx.NoContent(http.StatusOK) }
+	unauthorized := func(next dojo.Handler) dojo.Handler {
+		return func(ctx dojo.Context) error { return dojo.ErrUnauthorized }
+	}
+	app.Route.Get("/posts", handler, unauthorized)
+	app.Route.Post("/posts", handler)
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/posts", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get(dojo.HeaderAllow) != "GET, POST, OPTIONS" {
+		t.Fatalf("expected the allowed methods without the middlewares of the routes, got %d %q", rec.Code, rec.Header().Get(dojo.HeaderAllow))
+	}
+
+	// An explicit options route replaces the automatic one
+	app.Route.Options("/posts", func(ctx dojo.Context) error { return ctx.NoContent(http.StatusAccepted) })
+	rec = httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/posts", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the explicit options route, got %d", rec.Code)
+	}
+}
+
+func TestRouter_PreflightMiddlewares(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.MiddlewareRegistry.Register("cors", CORS())
+	app.MiddlewareRegistry.Register("secure", func(next dojo.Handler) dojo.Handler {
+		return func(ctx dojo.Context) error {
+			ctx.Response().Header().Set("X-Secure", "1")
+			return next(ctx)
+		}
+	})
+	app.MiddlewareRegistry.Register("auth", func(next dojo.Handler) dojo.Handler {
+		return func(ctx dojo.Context) error { return dojo.ErrUnauthorized }
+	})
+	app.Route.Use("auth")
+	app.Route.Use("cors")
+	app.Route.Use("secure")
+	handler := func(ctx dojo.Context) error { return ctx.NoContent(http.StatusOK) }
+
+	// The order the routers register the methods in doesn't matter
+	app.Route.Get("/orders", handler)
+	app.Route.WithoutMiddleware("secure").Post("/orders", handler)
+	app.Route.WithoutMiddleware("secure").Post("/items", handler)
+	app.Route.Get("/items", handler)
+
+	for _, path := range []string{"/orders", "/items"} {
+		for method, secure := range map[string]string{http.MethodGet: "1", http.MethodPost: ""} {
+			req := httptest.NewRequest(http.MethodOptions, path, nil)
+			req.Header.Set(dojo.HeaderOrigin, "https://example.com")
+			req.Header.Set(dojo.HeaderAccessControlRequestMethod, method)
+			rec := httptest.NewRecorder()
+			app.Route.GetMux().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("%s %s: expected the preflight to skip auth, got %d", path, method, rec.Code)
+			}
+			if got := rec.Header().Get("X-Secure"); got != secure {
+				t.Errorf("%s %s: expected the middlewares of the router of the method, X-Secure=%q", path, method, got)
+			}
+		}
+	}
+
+	// A method added after an explicit options route keeps it
+	app.Route.Options("/ping", func(ctx dojo.Context) error { return ctx.NoContent(http.StatusAccepted) })
+	app.Route.WithoutMiddleware("auth").Get("/ping", handler)
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/ping", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the explicit options route with the router middlewares, got %d", rec.Code)
+	}
+}
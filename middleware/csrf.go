@@ -1,13 +1,14 @@
 package middleware
 
 import (
+	"crypto/rand"
 	"crypto/subtle"
-	"errors"
+	"encoding/base64"
 	"fmt"
 	"github.com/gorilla/sessions"
 	"github.com/zengineDev/dojo"
-	"github.com/zengineDev/x/utilsx"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -17,6 +18,8 @@ type (
 
 		TokenLength uint8 `yaml:"token_length"`
 
+		// TokenLookup is a comma separated list of "<source>:<name>" where
+		// source is header, form or query. The first token found is used.
 		TokenLookup string `yaml:"token_lookup"`
 
 		ContextKey string `yaml:"context_key"`
@@ -24,40 +27,69 @@ type (
 		CookieName string `yaml:"cookie_name"`
 
 		CookieMaxAge int `yaml:"cookie_max_age"`
+
+		// TrustedOrigins are accepted next to the origin of AppConfig.Domain
+		TrustedOrigins []string `yaml:"trusted_origins"`
+	}
+
+	// CSRFError is the reason a request failed the csrf check, it is the
+	// internal error of the 403 response.
+	CSRFError struct {
+		Reason string
 	}
 
-	csrfTokenExtractor func(dojo.Context) (string, error)
+	csrfTokenExtractor func(dojo.Context) string
 )
 
+const csrfSessionKey = "value"
+
 var (
 	DefaultCSRFConfig = CSRFConfig{
 		Skipper:      DefaultSkipper,
 		TokenLength:  32,
-		TokenLookup:  "header:" + dojo.HeaderXCSRFToken,
+		TokenLookup:  "header:" + dojo.HeaderXCSRFToken + ",form:_csrf",
 		ContextKey:   "csrf",
 		CookieName:   "_csrf",
 		CookieMaxAge: 86400,
 	}
+
+	ErrCSRFTokenMissing   = &CSRFError{Reason: "missing csrf token"}
+	ErrCSRFTokenInvalid   = &CSRFError{Reason: "invalid csrf token"}
+	ErrCSRFOriginMismatch = &CSRFError{Reason: "cross origin request"}
 )
 
+func (e *CSRFError) Error() string {
+	return e.Reason
+}
+
 func CSRF() dojo.MiddlewareFunc {
 	config := DefaultCSRFConfig
 	return CSRFWithConfig(config)
 }
 
 func CSRFWithConfig(config CSRFConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCSRFConfig.Skipper
+	}
+	if config.TokenLength == 0 {
+		config.TokenLength = DefaultCSRFConfig.TokenLength
+	}
+	if config.TokenLookup == "" {
+		config.TokenLookup = DefaultCSRFConfig.TokenLookup
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = DefaultCSRFConfig.ContextKey
+	}
+	if config.CookieName == "" {
+		config.CookieName = DefaultCSRFConfig.CookieName
+	}
+	if config.CookieMaxAge == 0 {
+		config.CookieMaxAge = DefaultCSRFConfig.CookieMaxAge
+	}
 
-	cookieStore := sessions.NewCookieStore([]byte("secret"))
-	cookieStore.Options.HttpOnly = true
-
-	// Initialize
-	parts := strings.Split(config.TokenLookup, ":")
-	extractor := csrfTokenFromHeader(parts[1])
-	switch parts[0] {
-	case "form":
-		extractor = csrfTokenFromForm(parts[1])
-	case "query":
-		extractor = csrfTokenFromQuery(parts[1])
+	extractors, err := csrfExtractors(config.TokenLookup)
+	if err != nil {
+		panic(err.Error())
 	}
 
 	return func(next dojo.Handler) dojo.Handler {
@@ -67,70 +99,177 @@ func CSRFWithConfig(config CSRFConfig) dojo.MiddlewareFunc {
 				return next(context)
 			}
 
-			session, err := cookieStore.Get(context.Request(), config.CookieName)
-			token := ""
+			// The token is kept in a cookie that is signed and encrypted with the keys of the application
+			store := context.Dojo().SessionStore
+			session, err := store.Get(context.Request(), config.CookieName)
+			if err != nil || session == nil {
+				session = sessions.NewSession(store, config.CookieName)
+				opts := *store.Options
+				session.Options = &opts
+				session.IsNew = true
+			}
+			session.Options.MaxAge = config.CookieMaxAge
+			session.Options.HttpOnly = true
 
-			// Generate token
-			if err != nil {
-				token = utilsx.RandomString(int(config.TokenLength))
-			} else {
-				// Reuse token
-				token = fmt.Sprintf("%s", session.Values["value"])
+			token, _ := session.Values[csrfSessionKey].([]byte)
+			if len(token) != int(config.TokenLength) {
+				token = make([]byte, config.TokenLength)
+				if _, err := rand.Read(token); err != nil {
+					return err
+				}
 			}
 
 			switch context.Request().Method {
 			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
 			default:
-				clientToken, err := extractor(context)
-				if err != nil {
-					return err
+				if !sameOrigin(context, config.TrustedOrigins) {
+					return csrfForbidden(ErrCSRFOriginMismatch)
+				}
+				clientToken := ""
+				for _, extractor := range extractors {
+					if clientToken = extractor(context); clientToken != "" {
+						break
+					}
+				}
+				if clientToken == "" {
+					return csrfForbidden(ErrCSRFTokenMissing)
 				}
 				if !validateCSRFToken(token, clientToken) {
-					return dojo.NewHTTPError(http.StatusUnauthorized, "invalid csrf token")
+					return csrfForbidden(ErrCSRFTokenInvalid)
 				}
 			}
 
 			// Set CSRF in cookie
-			session.Values["value"] = token
-			err = session.Save(context.Request(), context.Response())
-			if err != nil {
+			session.Values[csrfSessionKey] = token
+			if err := session.Save(context.Request(), context.Response()); err != nil {
 				return dojo.NewHTTPError(http.StatusBadRequest, "session save error")
 			}
-			context.Set(config.ContextKey, token)
 
-			context.Response().Header().Set(dojo.HeaderVary, dojo.HeaderCookie)
+			// The token is masked differently on every response, so it can't be
+			// recovered from compressed responses (BREACH)
+			masked, err := maskCSRFToken(token)
+			if err != nil {
+				return err
+			}
+			context.Set(config.ContextKey, masked)
+
+			context.Response().Header().Add(dojo.HeaderVary, dojo.HeaderCookie)
 
 			return next(context)
 		}
 	}
 }
 
+func csrfExtractors(tokenLookup string) ([]csrfTokenExtractor, error) {
+	var extractors []csrfTokenExtractor
+	for _, lookup := range strings.Split(tokenLookup, ",") {
+		parts := strings.SplitN(strings.TrimSpace(lookup), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("dojo: invalid csrf token lookup %q, expected <source>:<name>", lookup)
+		}
+		switch parts[0] {
+		case "header":
+			extractors = append(extractors, csrfTokenFromHeader(parts[1]))
+		case "form":
+			extractors = append(extractors, csrfTokenFromForm(parts[1]))
+		case "query":
+			extractors = append(extractors, csrfTokenFromQuery(parts[1]))
+		default:
+			return nil, fmt.Errorf("dojo: unknown csrf token source %q", parts[0])
+		}
+	}
+	return extractors, nil
+}
+
+func csrfForbidden(err *CSRFError) error {
+	return &dojo.HTTPError{Code: http.StatusForbidden, Message: err.Reason, Internal: err}
+}
+
+// sameOrigin checks the Origin, or the Referer when there is no Origin,
+// against the domain of the application. Requests without both are accepted.
+func sameOrigin(ctx dojo.Context, trusted []string) bool {
+	req := ctx.Request()
+	origin := req.Header.Get(dojo.HeaderOrigin)
+	if origin == "" || origin == "null" {
+		referer := req.Referer()
+		if referer == "" {
+			return origin == ""
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	allowed := ctx.Dojo().Configuration.App.Domain
+	if allowed == "" {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		allowed = scheme + "://" + req.Host
+	}
+	if u, err := url.Parse(allowed); err == nil && u.Host != "" {
+		allowed = u.Scheme + "://" + u.Host
+	}
+
+	if strings.EqualFold(origin, allowed) {
+		return true
+	}
+	for _, o := range trusted {
+		if strings.EqualFold(origin, strings.TrimRight(o, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskCSRFToken returns base64(pad + (pad xor token)) with a random pad
+func maskCSRFToken(token []byte) (string, error) {
+	pad := make([]byte, len(token))
+	if _, err := rand.Read(pad); err != nil {
+		return "", err
+	}
+	masked := make([]byte, len(token)*2)
+	copy(masked, pad)
+	for i := range token {
+		masked[len(token)+i] = pad[i] ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked), nil
+}
+
+func unmaskCSRFToken(masked string) []byte {
+	raw, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(raw)%2 != 0 {
+		return nil
+	}
+	n := len(raw) / 2
+	token := make([]byte, n)
+	for i := 0; i < n; i++ {
+		token[i] = raw[i] ^ raw[n+i]
+	}
+	return token
+}
+
 func csrfTokenFromHeader(header string) csrfTokenExtractor {
-	return func(c dojo.Context) (string, error) {
-		return c.Request().Header.Get(header), nil
+	return func(c dojo.Context) string {
+		return c.Request().Header.Get(header)
 	}
 }
 
 func csrfTokenFromForm(param string) csrfTokenExtractor {
-	return func(c dojo.Context) (string, error) {
-		token := c.Request().FormValue(param)
-		if token == "" {
-			return "", errors.New("missing csrf token in the form parameter")
-		}
-		return token, nil
+	return func(c dojo.Context) string {
+		return c.Request().FormValue(param)
 	}
 }
 
 func csrfTokenFromQuery(param string) csrfTokenExtractor {
-	return func(c dojo.Context) (string, error) {
-		token := c.Params().Get(param)
-		if token == "" {
-			return "", errors.New("missing csrf token in the query string")
-		}
-		return token, nil
+	return func(c dojo.Context) string {
+		return c.Request().URL.Query().Get(param)
 	}
 }
 
-func validateCSRFToken(token, clientToken string) bool {
-	return subtle.ConstantTimeCompare([]byte(token), []byte(clientToken)) == 1
+func validateCSRFToken(token []byte, clientToken string) bool {
+	return subtle.ConstantTimeCompare(token, unmaskCSRFToken(clientToken)) == 1
 }
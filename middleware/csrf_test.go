@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCSRFApp() (*dojo.Dojo, *error) {
+	app := dojo.New(dojo.DefaultConfiguration{
+		App:     dojo.AppConfig{Domain: "https://example.com"},
+		Session: dojo.SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	var handled error
+	app.HTTPErrorHandler = func(err error, ctx dojo.Context) {
+		handled = err
+		app.DefaultHTTPErrorHandler(err, ctx)
+	}
+	app.Route.Get("/form", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, ctx.Value("csrf"))
+	}, CSRF())
+	app.Route.Post("/form", func(ctx dojo.Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	}, CSRF())
+	return app, &handled
+}
+
+func csrfToken(t *testing.T, app *dojo.Dojo) (string, []*http.Cookie) {
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/form", nil))
+	body := strings.TrimSpace(rec.Body.String())
+	token := strings.TrimSuffix(strings.TrimPrefix(body, `{"data":"`), `"}`)
+	if token == "" || len(rec.Result().Cookies()) == 0 {
+		t.Fatalf("expected a token and a cookie, got %s", body)
+	}
+	return token, rec.Result().Cookies()
+}
+
+func TestCSRF_MaskedTokens(t *testing.T) {
+	app, _ := newCSRFApp()
+	first, cookies := csrfToken(t, app)
+
+	// The token is masked differently on every request but stays valid
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/form", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	second := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), `{"data":"`), `"}`)
+	if first == second {
+		t.Fatal("expected the tokens to be masked per request")
+	}
+
+	for _, token := range []string{first, second} {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/form", nil)
+		req.Header.Set(dojo.HeaderXCSRFToken, token)
+		req.Header.Set(dojo.HeaderOrigin, "https://example.com")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected the token to be accepted, got %d", rec.Code)
+		}
+	}
+}
+
+func TestCSRF_FormToken(t *testing.T) {
+	app, _ := newCSRFApp()
+	token, cookies := csrfToken(t, app)
+
+	form := url.Values{"_csrf": {token}}
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/form", strings.NewReader(form.Encode()))
+	req.Header.Set(dojo.HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "https://example.com/form")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the form token to be accepted, got %d", rec.Code)
+	}
+}
+
+func TestCSRF_Rejects(t *testing.T) {
+	app, handled := newCSRFApp()
+	token, cookies := csrfToken(t, app)
+
+	cases := []struct {
+		name   string
+		token  string
+		origin string
+		expect error
+	}{
+		{"missing token", "", "https://example.com", ErrCSRFTokenMissing},
+		{"invalid token", "AAAA", "https://example.com", ErrCSRFTokenInvalid},
+		{"cross origin", token, "https://evil.example", ErrCSRFOriginMismatch},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://example.com/form", nil)
+			req.Header.Set(dojo.HeaderOrigin, c.origin)
+			if c.token != "" {
+				req.Header.Set(dojo.HeaderXCSRFToken, c.token)
+			}
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			app.Route.GetMux().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d", rec.Code)
+			}
+			var csrfErr *CSRFError
+			if !errors.As(*handled, &csrfErr) || !errors.Is(*handled, c.expect) {
+				t.Errorf("expected %v, got %v", c.expect, *handled)
+			}
+		})
+	}
+}
+
+func TestCSRF_InvalidTokenLookupPanics(t *testing.T) {
+	for _, lookup := range []string{"header", "header:", "cookie:_csrf", "header:X-CSRF-Token,form"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected the token lookup %q to panic at construction", lookup)
+				}
+			}()
+			CSRFWithConfig(CSRFConfig{TokenLookup: lookup})
+		}()
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"strings"
+)
+
+type (
+	ETagConfig struct {
+		Skipper Skipper
+
+		// Weak sends W/ etags, they only promise equivalent responses and
+		// survive transformations like compression.
+		Weak bool `yaml:"weak"`
+	}
+
+	// bodyRecorder buffers the response until the middleware writes it, a
+	// flushed response is streamed through instead.
+	bodyRecorder struct {
+		http.ResponseWriter
+		status      int
+		wroteHeader bool
+		body        bytes.Buffer
+		streaming   bool
+	}
+)
+
+var (
+	DefaultETagConfig = ETagConfig{
+		Skipper: DefaultSkipper,
+	}
+)
+
+// ETag hashes the body of GET and HEAD responses into an ETag and answers
+// If-None-Match requests with 304 when it matches.
+func ETag() dojo.MiddlewareFunc {
+	config := DefaultETagConfig
+	return ETagWithConfig(config)
+}
+
+func ETagWithConfig(config ETagConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultETagConfig.Skipper
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			req := context.Request()
+			if config.Skipper(context) || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+				return next(context)
+			}
+
+			// The handler writes to its own response, the one of the request is
+			// committed once it is known if the body or a 304 is sent
+			res := context.Response()
+			rec := newBodyRecorder(res)
+			hctx := dojo.ContextWithResponse(context, rec)
+			err := next(hctx)
+			if s := hctx.Session(); s != nil && s.Session != nil {
+				context.Session().Session.Values = s.Session.Values
+			}
+			if rec.streaming || (!rec.wroteHeader && rec.body.Len() == 0) {
+				return err
+			}
+
+			header := res.Header()
+			if rec.status == http.StatusOK && rec.body.Len() > 0 && header.Get(dojo.HeaderETag) == "" {
+				header.Set(dojo.HeaderETag, computeETag(rec.body.Bytes(), config.Weak))
+			}
+			if rec.status == http.StatusOK && etagMatches(req.Header.Get(dojo.HeaderIfNoneMatch), header.Get(dojo.HeaderETag)) {
+				header.Del(dojo.HeaderContentType)
+				header.Del(dojo.HeaderContentLength)
+				res.WriteHeader(http.StatusNotModified)
+				return err
+			}
+
+			rec.writeTo(res)
+			return err
+		}
+	}
+}
+
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// etagMatches compares the If-None-Match header weakly with the etag
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func newBodyRecorder(w http.ResponseWriter) *bodyRecorder {
+	return &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *bodyRecorder) WriteHeader(code int) {
+	if r.streaming {
+		r.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	if r.streaming {
+		return r.ResponseWriter.Write(b)
+	}
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+// Flush turns the recorder into a pass through writer
+func (r *bodyRecorder) Flush() {
+	if !r.streaming {
+		r.streaming = true
+		r.writeTo(r.ResponseWriter)
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *bodyRecorder) writeTo(w http.ResponseWriter) {
+	w.WriteHeader(r.status)
+	if r.body.Len() > 0 {
+		_, _ = w.Write(r.body.Bytes())
+	}
+}
@@ -28,16 +28,33 @@ func Guest() dojo.MiddlewareFunc {
 func GuestWithConfig(config GuestConfig) dojo.MiddlewareFunc {
 
 	if config.Skipper == nil {
-		config.Skipper = DefaultAuthenticationConfig.Skipper
+		config.Skipper = DefaultGuestConfig.Skipper
+	}
+	if config.RedirectPath == "" {
+		config.RedirectPath = DefaultGuestConfig.RedirectPath
 	}
 
 	return func(next dojo.Handler) dojo.Handler {
 		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
 			user := context.Dojo().Auth.GetAuthUser(context)
-			if !user.IsGuest() {
-				http.Redirect(context.Response(), context.Request(), config.RedirectPath, http.StatusFound)
+			if user.IsGuest() {
+				return next(context)
+			}
+
+			if dojo.ExpectsJSON(context) {
+				return dojo.ErrForbidden
 			}
-			return next(context)
+
+			http.Redirect(context.Response(), context.Request(), config.RedirectPath, http.StatusFound)
+			return nil
 		}
 	}
 }
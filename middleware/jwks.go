@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// jwksMinRefresh protects the jwks endpoint from tokens with random kids.
+const jwksMinRefresh = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// jwks caches the public keys of a json web key set.
+type jwks struct {
+	url       string
+	interval  time.Duration
+	client    *resty.Client
+	mu        sync.RWMutex
+	fetching  sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func newJWKS(url string, interval time.Duration) *jwks {
+	return &jwks{
+		url:      url,
+		interval: interval,
+		client:   resty.New().SetTimeout(10 * time.Second),
+		keys:     make(map[string]interface{}),
+	}
+}
+
+func (s *jwks) key(kid string) (interface{}, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	fetchedAt := s.fetchedAt
+	s.mu.RUnlock()
+	stale := time.Since(fetchedAt) > s.interval
+	recent := time.Since(fetchedAt) < jwksMinRefresh
+
+	if ok && !stale {
+		return key, nil
+	}
+	if !ok && recent {
+		return nil, fmt.Errorf("unknown jwks key %q", kid)
+	}
+
+	if err := s.refreshSince(fetchedAt); err != nil {
+		if ok {
+			// Keep using the known key when the endpoint is down
+			return key, nil
+		}
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown jwks key %q", kid)
+}
+
+// refreshSince fetches the keys once for all the requests that saw the keys
+// of fetchedAt, the requests that waited use the keys of the first one.
+func (s *jwks) refreshSince(fetchedAt time.Time) error {
+	s.fetching.Lock()
+	defer s.fetching.Unlock()
+
+	s.mu.RLock()
+	refreshed := s.fetchedAt.After(fetchedAt)
+	s.mu.RUnlock()
+	if refreshed {
+		return nil
+	}
+	return s.refresh()
+}
+
+func (s *jwks) refresh() error {
+	var set jsonWebKeySet
+	resp, err := s.client.R().SetResult(&set).Get(s.url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("jwks: unexpected status %d", resp.StatusCode())
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64BigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64BigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBase64BigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64BigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBase64BigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -1 +1,310 @@
 package middleware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	JWTConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		// SigningKey is used to verify the tokens, a []byte for HS256,
+		// a *rsa.PublicKey for RS256 or a *ecdsa.PublicKey for ES256.
+		SigningKey interface{}
+
+		// SigningKeys are looked up by the kid header of the token.
+		SigningKeys map[string]interface{}
+
+		SigningMethods []string `yaml:"signing_methods"`
+
+		// JWKSURL to load the verification keys from, the keys are looked up by kid.
+		JWKSURL string `yaml:"jwks_url"`
+
+		JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+
+		Issuer string `yaml:"issuer"`
+
+		Audience string `yaml:"audience"`
+
+		ClockSkew time.Duration `yaml:"clock_skew"`
+
+		// AllowMissingExpiry accepts tokens without an exp claim, they are
+		// rejected by default because they never expire.
+		AllowMissingExpiry bool `yaml:"allow_missing_expiry"`
+
+		// TokenLookup is a comma separated list of "<source>:<name>" where source is header, cookie or query.
+		TokenLookup string `yaml:"token_lookup"`
+
+		AuthScheme string `yaml:"auth_scheme"`
+
+		ContextKey string `yaml:"context_key"`
+
+		// UserMapper maps the claims of a valid token to the authenticated user.
+		UserMapper func(claims jwt.MapClaims) (dojo.Authenticable, error)
+	}
+
+	tokenExtractor func(dojo.Context) string
+)
+
+var (
+	DefaultJWTConfig = JWTConfig{
+		Skipper:             DefaultSkipper,
+		SigningMethods:      []string{"HS256"},
+		JWKSRefreshInterval: time.Hour,
+		TokenLookup:         "header:" + dojo.HeaderAuthorization,
+		AuthScheme:          "Bearer",
+		ContextKey:          "jwt",
+		UserMapper:          DefaultJWTUserMapper,
+	}
+
+	// jwtClaimsKey stores the claims independent of the configured context key
+	jwtClaimsKey = "dojo.jwt.claims"
+
+	ErrJWTMissing = dojo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+	ErrJWTInvalid = dojo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+)
+
+func JWT(key interface{}) dojo.MiddlewareFunc {
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	return JWTWithConfig(config)
+}
+
+func JWTWithConfig(config JWTConfig) dojo.MiddlewareFunc {
+	config = normalizeJWTConfig(config)
+	authenticate := newJWTAuthenticator(config)
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			user, err := authenticate(context)
+			if err != nil {
+				return err
+			}
+			context.Dojo().Auth.SetUser(context, user)
+
+			return next(context)
+		}
+	}
+}
+
+// JWTAuthenticator authenticates the requests of a guard with the jwt driver
+func JWTAuthenticator(config JWTConfig) dojo.GuardAuthenticator {
+	config = normalizeJWTConfig(config)
+	return newJWTAuthenticator(config)
+}
+
+// newJWTAuthenticator verifies the token of the request, stores the claims on
+// the context and maps them to the user.
+func newJWTAuthenticator(config JWTConfig) func(dojo.Context) (dojo.Authenticable, error) {
+	var keySet *jwks
+	if config.JWKSURL != "" {
+		keySet = newJWKS(config.JWKSURL, config.JWKSRefreshInterval)
+	}
+
+	extractors, err := jwtExtractors(config)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(config.SigningMethods), jwt.WithoutClaimsValidation())
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid != "" {
+			if key, ok := config.SigningKeys[kid]; ok {
+				return key, nil
+			}
+			if keySet != nil {
+				return keySet.key(kid)
+			}
+		}
+		if config.SigningKey != nil {
+			return config.SigningKey, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return func(context dojo.Context) (dojo.Authenticable, error) {
+		raw := ""
+		for _, extractor := range extractors {
+			if raw = extractor(context); raw != "" {
+				break
+			}
+		}
+		if raw == "" {
+			return nil, jwtChallenge(context, ErrJWTMissing, nil)
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(raw, claims, keyFunc); err != nil {
+			return nil, jwtChallenge(context, ErrJWTInvalid, err)
+		}
+		if err := validateJWTClaims(claims, config); err != nil {
+			return nil, jwtChallenge(context, ErrJWTInvalid, err)
+		}
+
+		user, err := config.UserMapper(claims)
+		if err != nil {
+			return nil, jwtChallenge(context, ErrJWTInvalid, err)
+		}
+
+		context.Set(config.ContextKey, claims)
+		context.Set(jwtClaimsKey, claims)
+		return user, nil
+	}
+}
+
+// jwtExtractors returns the extractors of the token lookup, the sources are
+// tried in the configured order.
+func jwtExtractors(config JWTConfig) ([]tokenExtractor, error) {
+	var extractors []tokenExtractor
+	for _, lookup := range strings.Split(config.TokenLookup, ",") {
+		parts := strings.SplitN(strings.TrimSpace(lookup), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("dojo: invalid jwt token lookup %q, expected <source>:<name>", lookup)
+		}
+		switch parts[0] {
+		case "header":
+			extractors = append(extractors, tokenFromHeader(parts[1], config.AuthScheme))
+		case "cookie":
+			extractors = append(extractors, tokenFromCookie(parts[1]))
+		case "query":
+			extractors = append(extractors, tokenFromQuery(parts[1]))
+		default:
+			return nil, fmt.Errorf("dojo: unknown jwt token source %q", parts[0])
+		}
+	}
+	return extractors, nil
+}
+
+func normalizeJWTConfig(config JWTConfig) JWTConfig {
+	if config.Skipper == nil {
+		config.Skipper = DefaultJWTConfig.Skipper
+	}
+	if len(config.SigningMethods) == 0 {
+		config.SigningMethods = DefaultJWTConfig.SigningMethods
+	}
+	if config.TokenLookup == "" {
+		config.TokenLookup = DefaultJWTConfig.TokenLookup
+	}
+	if config.AuthScheme == "" {
+		config.AuthScheme = DefaultJWTConfig.AuthScheme
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = DefaultJWTConfig.ContextKey
+	}
+	if config.UserMapper == nil {
+		config.UserMapper = DefaultJWTConfig.UserMapper
+	}
+	if config.JWKSRefreshInterval == 0 {
+		config.JWKSRefreshInterval = DefaultJWTConfig.JWKSRefreshInterval
+	}
+	if config.SigningKey == nil && len(config.SigningKeys) == 0 && config.JWKSURL == "" {
+		panic("dojo: jwt middleware requires a signing key or a jwks url")
+	}
+	return config
+}
+
+// JWTClaims returns the claims the jwt middleware stored on the context,
+// whatever context key the middleware is configured with.
+func JWTClaims(ctx dojo.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(jwtClaimsKey).(jwt.MapClaims)
+	return claims
+}
+
+// DefaultJWTUserMapper uses the sub claim as user id and the claims as user
+// data. Subjects that aren't uuids are used as string identifiers.
+func DefaultJWTUserMapper(claims jwt.MapClaims) (dojo.Authenticable, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+	user := &dojo.AuthUser{Data: map[string]interface{}(claims)}
+	if id, err := uuid.FromString(sub); err == nil {
+		user.Identifier = dojo.UUIDIdentifier(id)
+	} else {
+		user.Identifier = dojo.StringIdentifier(sub)
+	}
+	return user, nil
+}
+
+func validateJWTClaims(claims jwt.MapClaims, config JWTConfig) error {
+	now := time.Now()
+	if _, ok := claims["exp"]; !ok && !config.AllowMissingExpiry {
+		return errors.New("token has no expiry")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-config.ClockSkew).Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(config.ClockSkew).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(config.ClockSkew).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if config.Issuer != "" && !claims.VerifyIssuer(config.Issuer, true) {
+		return errors.New("invalid issuer")
+	}
+	if config.Audience != "" && !claims.VerifyAudience(config.Audience, true) {
+		return errors.New("invalid audience")
+	}
+	return nil
+}
+
+func jwtChallenge(ctx dojo.Context, he *dojo.HTTPError, err error) error {
+	challenge := "Bearer"
+	if he == ErrJWTInvalid {
+		challenge = `Bearer error="invalid_token"`
+	}
+	ctx.Response().Header().Set(dojo.HeaderWWWAuthenticate, challenge)
+	if err == nil {
+		return he
+	}
+	return &dojo.HTTPError{Code: he.Code, Message: he.Message, Internal: err}
+}
+
+func tokenFromHeader(header string, authScheme string) tokenExtractor {
+	prefix := authScheme + " "
+	return func(c dojo.Context) string {
+		auth := c.Request().Header.Get(header)
+		if authScheme == "" {
+			return auth
+		}
+		if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+			return auth[len(prefix):]
+		}
+		return ""
+	}
+}
+
+func tokenFromCookie(name string) tokenExtractor {
+	return func(c dojo.Context) string {
+		token, err := c.Cookies().Get(name)
+		if err != nil {
+			return ""
+		}
+		return token
+	}
+}
+
+func tokenFromQuery(param string) tokenExtractor {
+	return func(c dojo.Context) string {
+		return c.Request().URL.Query().Get(param)
+	}
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/steinfletcher/apitest"
+	"github.com/steinfletcher/apitest-jsonpath"
+	"github.com/zengineDev/dojo"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testJWTSubject = "3d9d1b1c-6d0a-4a59-9c59-2d1c3f5d7e10"
+
+func newJWTApp(config JWTConfig) *dojo.Dojo {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	app.Route.Get("/me", func(ctx dojo.Context) error {
+		user := ctx.Dojo().Auth.GetAuthUser(ctx)
+		return ctx.JSON(http.StatusOK, map[string]string{"id": user.GetAuthID().String()})
+	}, JWTWithConfig(config))
+	return app
+}
+
+func signHS256(t *testing.T, claims jwt.MapClaims, key []byte) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWT_HS256(t *testing.T) {
+	key := []byte("secret")
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	config.Audience = "dojo"
+	config.ClockSkew = time.Minute
+	app := newJWTApp(config)
+
+	valid := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "dojo", "exp": time.Now().Add(time.Hour).Unix()}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+valid).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.id`, testJWTSubject)).
+		Status(http.StatusOK).
+		End()
+
+	withinSkew := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "dojo", "exp": time.Now().Add(-30 * time.Second).Unix()}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+withinSkew).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	expired := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "dojo", "exp": time.Now().Add(-time.Hour).Unix()}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+expired).
+		Expect(t).
+		Header(dojo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`).
+		Status(http.StatusUnauthorized).
+		End()
+
+	noExpiry := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "dojo"}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+noExpiry).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	wrongAudience := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "other"}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+wrongAudience).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	wrongKey := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "aud": "dojo"}, []byte("other"))
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+wrongKey).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Expect(t).
+		Header(dojo.HeaderWWWAuthenticate, "Bearer").
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+func TestJWT_CookieLookup(t *testing.T) {
+	key := []byte("secret")
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	config.TokenLookup = "header:Authorization,cookie:token"
+	app := newJWTApp(config)
+
+	token := signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "exp": time.Now().Add(time.Hour).Unix()}, key)
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Cookie("token", token).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.id`, testJWTSubject)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestJWT_RS256WithJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(dojo.HeaderContentType, dojo.MIMEApplicationJSON)
+		_, _ = w.Write([]byte(`{"keys":[{"kty":"RSA","kid":"k1","use":"sig","alg":"RS256","n":"` +
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()) + `","e":"` +
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()) + `"}]}`))
+	}))
+	defer server.Close()
+
+	config := DefaultJWTConfig
+	config.SigningMethods = []string{"RS256"}
+	config.JWKSURL = server.URL
+	config.Issuer = "https://id.example.com"
+	app := newJWTApp(config)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": testJWTSubject, "iss": "https://id.example.com", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+signed).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.id`, testJWTSubject)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestJWT_ES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultJWTConfig
+	config.SigningMethods = []string{"ES256"}
+	config.SigningKeys = map[string]interface{}{"ec": &key.PublicKey}
+	app := newJWTApp(config)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": testJWTSubject, "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "ec"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+signed).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.id`, testJWTSubject)).
+		Status(http.StatusOK).
+		End()
+
+	// A token of another signing method is rejected, even with a known key
+	hs := signHS256(t, jwt.MapClaims{"sub": testJWTSubject}, []byte("secret"))
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+hs).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+func TestJWT_ClaimsWithCustomContextKey(t *testing.T) {
+	key := []byte("secret")
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	config.ContextKey = "token"
+
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.Route.Get("/claims", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, map[string]interface{}{"sub": JWTClaims(ctx)["sub"], "key": ctx.Value("token") != nil})
+	}, JWTWithConfig(config))
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/claims").
+		Header(dojo.HeaderAuthorization, "Bearer "+signHS256(t, jwt.MapClaims{"sub": testJWTSubject, "exp": time.Now().Add(time.Hour).Unix()}, key)).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.sub`, testJWTSubject)).
+		Assert(jsonpath.Equal(`$.data.key`, true)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestJWT_AllowMissingExpiry(t *testing.T) {
+	key := []byte("secret")
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	config.AllowMissingExpiry = true
+	app := newJWTApp(config)
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/me").
+		Header(dojo.HeaderAuthorization, "Bearer "+signHS256(t, jwt.MapClaims{"sub": testJWTSubject}, key)).
+		Expect(t).
+		Assert(jsonpath.Equal(`$.data.id`, testJWTSubject)).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestJWT_InvalidTokenLookupPanics(t *testing.T) {
+	for _, lookup := range []string{"Authorization", "header:", "body:token"} {
+		config := DefaultJWTConfig
+		config.SigningKey = []byte("secret")
+		config.TokenLookup = lookup
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected the token lookup %q to panic at construction", lookup)
+				}
+			}()
+			JWTWithConfig(config)
+		}()
+	}
+}
+
+func TestJWKS_ConcurrentRefreshFetchesOnce(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set(dojo.HeaderContentType, dojo.MIMEApplicationJSON)
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	keys := newJWKS(server.URL, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = keys.key("unknown")
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the key set to be fetched once, got %d", n)
+	}
+}
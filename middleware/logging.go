@@ -23,15 +23,27 @@ func Logging() dojo.MiddlewareFunc {
 }
 
 func LoggingWithConfig(config LoggingConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultLoggingConfig.Skipper
+	}
+
 	return func(next dojo.Handler) dojo.Handler {
 		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
 			fields := make(map[string]interface{})
 
 			start := time.Now()
-			if err := next(context); err != nil {
-				return err
+			err := next(context)
+			if err != nil {
+				// The error is handled here, so the response has its final status
+				context.Dojo().HTTPErrorHandler(err, context)
+				fields["error"] = err.Error()
 			}
 			stop := time.Now()
+			res := context.Response()
 			p := context.Request().URL.Path
 			if p == "" {
 				p = "/"
@@ -41,30 +53,21 @@ func LoggingWithConfig(config LoggingConfig) dojo.MiddlewareFunc {
 			fields["uri"] = context.Request().RequestURI
 			fields["host"] = context.Request().Host
 			fields["env"] = context.Dojo().Configuration.App.Environment
-			id := context.Request().Header.Get(dojo.HeaderXRequestID)
+			id, _ := context.Value(dojo.RequestIDKey).(string)
 			if id == "" {
-				id = context.Response().Header().Get(dojo.HeaderXRequestID)
+				id = res.Header().Get(dojo.HeaderXRequestID)
 			}
 			fields["id"] = id
 			fields["remote_ip"] = context.RealIP()
 			fields["start"] = start
 			fields["stop"] = stop
+			fields["latency"] = stop.Sub(start).String()
 			fields["contentType"] = context.Request().Header.Get(dojo.HeaderContentType)
 			fields["userAgent"] = context.Request().UserAgent()
 			fields["referer"] = context.Request().Referer()
 			fields["protocol"] = context.Request().Proto
-			//n := context.Response().Status()
-			n := 200
-			var s int
-			switch {
-			case n >= 500:
-				s = n
-			case n >= 400:
-				s = n
-			case n >= 300:
-				s = n
-			}
-			fields["status"] = s
+			fields["status"] = res.Status
+			fields["bytes_out"] = res.Size
 
 			context.Dojo().Logger.WithFields(fields).Info("request_log")
 			return nil
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogging_Status(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	hook := test.NewLocal(app.Logger)
+	app.Route.Get("/created", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusCreated, "ok")
+	}, Logging())
+	app.Route.Get("/missing", func(ctx dojo.Context) error {
+		return dojo.NewHTTPError(http.StatusNotFound, "missing")
+	}, Logging())
+
+	cases := map[string]int{"/created": http.StatusCreated, "/missing": http.StatusNotFound}
+	for path, status := range cases {
+		hook.Reset()
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Fatalf("expected %d for %s, got %d", status, path, rec.Code)
+		}
+
+		entry := hook.LastEntry()
+		if entry == nil {
+			t.Fatalf("expected %s to be logged", path)
+		}
+		if entry.Data["status"] != status {
+			t.Errorf("expected the logged status %d for %s, got %v", status, path, entry.Data["status"])
+		}
+		if size, _ := entry.Data["bytes_out"].(int64); size != int64(rec.Body.Len()) {
+			t.Errorf("expected %d bytes for %s, got %v", rec.Body.Len(), path, entry.Data["bytes_out"])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+)
+
+type (
+	RoleConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		// Roles the user needs at least one of
+		Roles []string
+	}
+
+	PermissionConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		// Permissions the user needs all of
+		Permissions []string
+	}
+)
+
+var (
+	DefaultRoleConfig = RoleConfig{
+		Skipper: DefaultSkipper,
+	}
+
+	DefaultPermissionConfig = PermissionConfig{
+		Skipper: DefaultSkipper,
+	}
+)
+
+func RequireRole(roles ...string) dojo.MiddlewareFunc {
+	config := DefaultRoleConfig
+	config.Roles = roles
+	return RequireRoleWithConfig(config)
+}
+
+func RequireRoleWithConfig(config RoleConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultRoleConfig.Skipper
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			auth := context.Dojo().Auth
+			if user := auth.GetAuthUser(context); user.IsGuest() {
+				return dojo.ErrUnauthorized
+			}
+
+			ok, err := auth.HasRole(context, config.Roles...)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return dojo.ErrForbidden
+			}
+
+			return next(context)
+		}
+	}
+}
+
+func RequirePermission(permissions ...string) dojo.MiddlewareFunc {
+	config := DefaultPermissionConfig
+	config.Permissions = permissions
+	return RequirePermissionWithConfig(config)
+}
+
+func RequirePermissionWithConfig(config PermissionConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultPermissionConfig.Skipper
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			auth := context.Dojo().Auth
+			if user := auth.GetAuthUser(context); user.IsGuest() {
+				return dojo.ErrUnauthorized
+			}
+
+			access, err := auth.Access(context)
+			if err != nil {
+				return err
+			}
+			for _, permission := range config.Permissions {
+				if !access.HasPermission(permission) {
+					return dojo.ErrForbidden
+				}
+			}
+
+			return next(context)
+		}
+	}
+}
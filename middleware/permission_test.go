@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/gob"
+	"github.com/gofrs/uuid"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type rolesTestData struct {
+	Roles       []string
+	Permissions []string
+}
+
+func (d rolesTestData) GetRoles() []string {
+	return d.Roles
+}
+
+func (d rolesTestData) GetPermissions() []string {
+	return d.Permissions
+}
+
+func TestRequireRoleAndPermission(t *testing.T) {
+	gob.Register(rolesTestData{})
+	app := newAuthApp()
+	app.Route.Get("/login-editor", func(ctx dojo.Context) error {
+		data := rolesTestData{Roles: []string{"editor"}, Permissions: []string{"posts.*"}}
+		return ctx.Dojo().Auth.Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: data})
+	})
+	called := false
+	app.Route.Get("/editor", protectedHandler(&called), RequireRole("admin", "editor"))
+	app.Route.Get("/admin", protectedHandler(&called), RequireRole("admin"))
+	app.Route.Get("/publish", protectedHandler(&called), RequirePermission("posts.create", "posts.publish"))
+	app.Route.Get("/users", protectedHandler(&called), RequirePermission("posts.create", "users.delete"))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login-editor", nil))
+	editor := rec.Result().Cookies()
+
+	cases := []struct {
+		path    string
+		cookies []*http.Cookie
+		code    int
+	}{
+		{"/editor", nil, http.StatusUnauthorized},
+		{"/publish", nil, http.StatusUnauthorized},
+		{"/editor", editor, http.StatusOK},
+		{"/admin", editor, http.StatusForbidden},
+		{"/publish", editor, http.StatusOK},
+		{"/users", editor, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		called = false
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		for _, cookie := range c.cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if rec.Code != c.code || called != (c.code == http.StatusOK) {
+			t.Errorf("%s (user %v): expected %d, got %d called=%v", c.path, c.cookies != nil, c.code, rec.Code, called)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/zengineDev/dojo"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies matches the remote address of a request against a list of
+// ips and cidr ranges.
+type trustedProxies []*net.IPNet
+
+func newTrustedProxies(proxies []string) trustedProxies {
+	var nets trustedProxies
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			panic(fmt.Sprintf("dojo: invalid trusted proxy %q: %v", p, err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// trusts reports if the request comes directly from a trusted proxy
+func (t trustedProxies) trusts(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPS reports if the request was made with https, the forwarded headers
+// are only read from trusted proxies.
+func (t trustedProxies) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !t.trusts(r) {
+		return false
+	}
+	if proto := r.Header.Get(dojo.HeaderXForwardedProto); proto != "" {
+		return strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https")
+	}
+	if proto := r.Header.Get(dojo.HeaderXForwardedProtocol); proto != "" {
+		return strings.EqualFold(proto, "https")
+	}
+	if strings.EqualFold(r.Header.Get(dojo.HeaderXForwardedSsl), "on") {
+		return true
+	}
+	return strings.EqualFold(r.Header.Get(dojo.HeaderXUrlScheme), "https")
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/zengineDev/dojo"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	RateLimitConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		// Name separates the limiters that share a store, the requests of
+		// limiters with the same name and limit are counted together, in the
+		// memory of the process or in redis for all instances. A limiter
+		// without name only counts its own requests.
+		Name string `yaml:"name"`
+
+		// Limit is the number of requests that are allowed per Period
+		Limit int `yaml:"limit"`
+
+		Period time.Duration `yaml:"period"`
+
+		Algorithm RateLimitAlgorithm `yaml:"algorithm"`
+
+		// Key is ip, user or token and is used when there is no KeyFunc
+		Key string `yaml:"key"`
+
+		// KeyFunc returns the key the requests are counted for
+		KeyFunc RateLimitKeyFunc `yaml:"-"`
+
+		// Store is the storage of the counters, the StoreDriver decides about
+		// it when it is nil. The memory limiters of the process share one
+		// store, the redis store uses the RedisConfig of the app.
+		Store RateLimitStore `yaml:"-"`
+
+		StoreDriver dojo.ThrottleStoreDriver `yaml:"store"`
+	}
+
+	RateLimitAlgorithm string
+
+	RateLimitKeyFunc func(ctx dojo.Context) string
+)
+
+const (
+	TokenBucket   RateLimitAlgorithm = "token_bucket"
+	SlidingWindow RateLimitAlgorithm = "sliding_window"
+)
+
+var (
+	DefaultRateLimitConfig = RateLimitConfig{
+		Skipper:     DefaultSkipper,
+		Limit:       60,
+		Period:      time.Minute,
+		Algorithm:   SlidingWindow,
+		Key:         "ip",
+		StoreDriver: dojo.MemoryThrottleStoreDriver,
+	}
+
+	// memoryRateLimitStore is shared by the memory limiters, like the redis
+	// store is shared by the limiters of all instances
+	memoryRateLimitStore = NewMemoryRateLimitStore()
+
+	rateLimiters uint64
+)
+
+// RateLimit limits the requests per ip with a spec like "60,1m", see ParseRateLimit.
+func RateLimit(spec string) dojo.MiddlewareFunc {
+	config := DefaultRateLimitConfig
+	limit, period, err := ParseRateLimit(spec)
+	if err != nil {
+		panic(err)
+	}
+	config.Limit = limit
+	config.Period = period
+	return RateLimitWithConfig(config)
+}
+
+func RateLimitWithConfig(config RateLimitConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultRateLimitConfig.Skipper
+	}
+	if config.Limit <= 0 {
+		config.Limit = DefaultRateLimitConfig.Limit
+	}
+	if config.Period <= 0 {
+		config.Period = DefaultRateLimitConfig.Period
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = DefaultRateLimitConfig.Algorithm
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = rateLimitKeyFunc(config.Key)
+	}
+
+	limit := RateLimitRule{Limit: config.Limit, Period: config.Period, Algorithm: config.Algorithm}
+	name := config.Name
+	if name == "" {
+		// The limiters are numbered in the order they are created, so the
+		// instances of an application agree on the names
+		name = fmt.Sprintf("limiter%d", atomic.AddUint64(&rateLimiters, 1))
+	}
+	prefix := fmt.Sprintf("%s:%s:%d:%s:", name, config.Algorithm, config.Limit, config.Period)
+
+	// The redis store needs the application, so it is created with the first request
+	var once sync.Once
+	store := func(ctx dojo.Context) RateLimitStore {
+		once.Do(func() {
+			if config.Store != nil {
+				return
+			}
+			if config.StoreDriver == dojo.RedisThrottleStoreDriver {
+				config.Store = NewRedisRateLimitStore(ctx.Dojo().Redis())
+			} else {
+				config.Store = memoryRateLimitStore
+			}
+		})
+		return config.Store
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			result, err := store(context).Take(context, prefix+config.KeyFunc(context), limit)
+			if err != nil {
+				// The requests are not limited while the store is unavailable
+				context.Logger().WithError(err).Error("rate limit store failed")
+				return next(context)
+			}
+
+			header := context.Response().Header()
+			header.Set(dojo.HeaderRateLimitLimit, strconv.Itoa(config.Limit))
+			header.Set(dojo.HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+			header.Set(dojo.HeaderRateLimitReset, strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				header.Set(dojo.HeaderRetryAfter, strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				return dojo.ErrTooManyRequests
+			}
+
+			return next(context)
+		}
+	}
+}
+
+// ParseRateLimit parses "<limit>,<period>" like "60,1m". A period without
+// unit is in minutes, so "60,1" allows 60 requests per minute.
+func ParseRateLimit(spec string) (int, time.Duration, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("dojo: invalid rate limit %q, expected <limit>,<period>", spec)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("dojo: invalid rate limit %q: the limit must be a positive number", spec)
+	}
+	p := strings.TrimSpace(parts[1])
+	if minutes, err := strconv.Atoi(p); err == nil {
+		p = strconv.Itoa(minutes) + "m"
+	}
+	period, err := time.ParseDuration(p)
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("dojo: invalid rate limit %q: the period must be a positive duration", spec)
+	}
+	return limit, period, nil
+}
+
+// RateLimitByIP counts the requests per ip
+func RateLimitByIP(ctx dojo.Context) string {
+	return "ip:" + ctx.RealIP()
+}
+
+// RateLimitByUser counts the requests per authenticated user, guests are counted per ip
+func RateLimitByUser(ctx dojo.Context) string {
+	user := ctx.Dojo().Auth.GetAuthUser(ctx)
+	if user.IsGuest() {
+		return RateLimitByIP(ctx)
+	}
+	return "user:" + user.GetAuthIdentifier().String()
+}
+
+// RateLimitByToken counts the requests per bearer token, requests without
+// token are counted per ip. The tokens are hashed so they are not stored.
+func RateLimitByToken(ctx dojo.Context) string {
+	const prefix = "Bearer "
+	auth := ctx.Request().Header.Get(dojo.HeaderAuthorization)
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return RateLimitByIP(ctx)
+	}
+	sum := sha256.Sum256([]byte(auth[len(prefix):]))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+func rateLimitKeyFunc(key string) RateLimitKeyFunc {
+	switch key {
+	case "user":
+		return RateLimitByUser
+	case "token":
+		return RateLimitByToken
+	case "", "ip":
+		return RateLimitByIP
+	default:
+		panic(fmt.Sprintf("dojo: unknown rate limit key %q", key))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
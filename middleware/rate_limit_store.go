@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// RateLimitStore counts the requests of the rate limiters
+	RateLimitStore interface {
+		// Take counts a request for the key when the rule allows it
+		Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+	}
+
+	RateLimitRule struct {
+		Limit     int
+		Period    time.Duration
+		Algorithm RateLimitAlgorithm
+	}
+
+	RateLimitResult struct {
+		Allowed   bool
+		Remaining int
+		// Reset is the time until the limit is fully available again
+		Reset time.Duration
+		// RetryAfter is the time until the next request is allowed when it was denied
+		RetryAfter time.Duration
+	}
+)
+
+// tokenBucketResult describes a bucket with the tokens left after the request
+func tokenBucketResult(rule RateLimitRule, tokens float64, allowed bool) RateLimitResult {
+	perToken := float64(rule.Period) / float64(rule.Limit)
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(rule.Limit) - tokens) * perToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return result
+}
+
+// slidingWindowResult weights the requests of the previous window with the
+// part of it that still overlaps the sliding window.
+func slidingWindowResult(rule RateLimitRule, elapsed time.Duration, prev, curr int, allowed bool) RateLimitResult {
+	period := float64(rule.Period)
+	estimate := float64(prev)*(1-float64(elapsed)/period) + float64(curr)
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(float64(rule.Limit)-estimate))),
+		Reset:     rule.Period - elapsed,
+	}
+	if allowed {
+		return result
+	}
+
+	if curr+1 <= rule.Limit && prev > 0 {
+		// The previous window has to slide out far enough for one more request
+		wait := period*(1-float64(rule.Limit-curr-1)/float64(prev)) - float64(elapsed)
+		result.RetryAfter = time.Duration(math.Max(wait, 0))
+	} else {
+		// The current window becomes the previous one
+		wait := period * (1 - float64(rule.Limit-1)/float64(curr))
+		result.RetryAfter = result.Reset + time.Duration(math.Max(wait, 0))
+	}
+	return result
+}
+
+type memoryRateLimitEntry struct {
+	// tokens and last are the state of the token bucket
+	tokens float64
+	last   time.Time
+
+	// window is the number of the current window of the sliding window
+	window int64
+	prev   int
+	curr   int
+
+	expiresAt time.Time
+}
+
+// MemoryRateLimitStore keeps the counters in the memory of the process.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryRateLimitEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries: make(map[string]*memoryRateLimitEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryRateLimitEntry{tokens: float64(rule.Limit), last: now, window: -1}
+		s.entries[key] = e
+	}
+	e.expiresAt = now.Add(2 * rule.Period)
+
+	if rule.Algorithm == TokenBucket {
+		rate := float64(rule.Limit) / float64(rule.Period)
+		e.tokens = math.Min(float64(rule.Limit), e.tokens+float64(now.Sub(e.last))*rate)
+		e.last = now
+		allowed := e.tokens >= 1
+		if allowed {
+			e.tokens--
+		}
+		return tokenBucketResult(rule, e.tokens, allowed), nil
+	}
+
+	window := now.UnixNano() / int64(rule.Period)
+	if e.window != window {
+		if e.window == window-1 {
+			e.prev = e.curr
+		} else {
+			e.prev = 0
+		}
+		e.curr = 0
+		e.window = window
+	}
+	elapsed := time.Duration(now.UnixNano() - window*int64(rule.Period))
+	estimate := float64(e.prev)*(1-float64(elapsed)/float64(rule.Period)) + float64(e.curr)
+	allowed := estimate+1 <= float64(rule.Limit)
+	if allowed {
+		e.curr++
+	}
+	return slidingWindowResult(rule, elapsed, e.prev, e.curr, allowed), nil
+}
+
+// sweep removes the expired counters once a minute
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+var tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or limit
+local last = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - last) * limit / period)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, tostring(tokens)}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local weight = tonumber(ARGV[3])
+local curr = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local allowed = 0
+if prev * weight + curr + 1 <= limit then
+	curr = redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], period * 2)
+	allowed = 1
+end
+return {allowed, prev, curr}
+`)
+
+// RedisRateLimitStore keeps the counters in redis so they are shared between instances.
+type RedisRateLimitStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{Client: client, Prefix: "dojo:ratelimit:"}
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	now := time.Now()
+	period := rule.Period.Milliseconds()
+
+	if rule.Algorithm == TokenBucket {
+		res, err := tokenBucketScript.Run(ctx, s.Client, []string{s.Prefix + key}, rule.Limit, period, now.UnixNano()/int64(time.Millisecond)).Slice()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		tokens, err := strconv.ParseFloat(res[1].(string), 64)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return tokenBucketResult(rule, tokens, res[0].(int64) == 1), nil
+	}
+
+	window := now.UnixNano() / int64(rule.Period)
+	elapsed := time.Duration(now.UnixNano() - window*int64(rule.Period))
+	weight := 1 - float64(elapsed)/float64(rule.Period)
+	keys := []string{
+		s.Prefix + key + ":" + strconv.FormatInt(window, 10),
+		s.Prefix + key + ":" + strconv.FormatInt(window-1, 10),
+	}
+	res, err := slidingWindowScript.Run(ctx, s.Client, keys, rule.Limit, period, strconv.FormatFloat(weight, 'f', -1, 64)).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return slidingWindowResult(rule, elapsed, int(res[1].(int64)), int(res[2].(int64)), res[0].(int64) == 1), nil
+}
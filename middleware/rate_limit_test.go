@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	cases := map[string]struct {
+		limit  int
+		period time.Duration
+	}{
+		"60,1m":  {60, time.Minute},
+		"60,1":   {60, time.Minute},
+		"10, 1s": {10, time.Second},
+	}
+	for spec, c := range cases {
+		limit, period, err := ParseRateLimit(spec)
+		if err != nil || limit != c.limit || period != c.period {
+			t.Errorf("%s: expected %d per %s, got %d per %s (%v)", spec, c.limit, c.period, limit, period, err)
+		}
+	}
+	for _, spec := range []string{"", "60", "0,1m", "60,soon"} {
+		if _, _, err := ParseRateLimit(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+}
+
+func TestRateLimit_Headers(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	called := false
+	app.Route.Get("/search", protectedHandler(&called), RateLimit("2,1m"))
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.RemoteAddr = ip + ":4000"
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := request("10.0.0.1")
+		if rec.Code != http.StatusOK || rec.Header().Get(dojo.HeaderRateLimitRemaining) != remaining {
+			t.Fatalf("request %d: expected 200 with %s remaining, got %d %q", i+1, remaining, rec.Code, rec.Header().Get(dojo.HeaderRateLimitRemaining))
+		}
+		if rec.Header().Get(dojo.HeaderRateLimitLimit) != "2" {
+			t.Errorf("expected the limit header, got %q", rec.Header().Get(dojo.HeaderRateLimitLimit))
+		}
+	}
+
+	rec := request("10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(dojo.HeaderRetryAfter) == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", rec.Code, rec.Header().Get(dojo.HeaderRetryAfter))
+	}
+
+	if rec := request("10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("expected another ip to have its own limit, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit_SharedByName(t *testing.T) {
+	stores := map[string]func() RateLimitStore{
+		"memory": func() RateLimitStore { return nil },
+	}
+	if addr := os.Getenv("DOJO_TEST_REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		defer client.Close()
+		store := NewRedisRateLimitStore(client)
+		store.Prefix = fmt.Sprintf("dojo:test:%d:", time.Now().UnixNano())
+		defer func() {
+			keys, _ := client.Keys(context.Background(), store.Prefix+"*").Result()
+			if len(keys) > 0 {
+				client.Del(context.Background(), keys...)
+			}
+		}()
+		stores["redis"] = func() RateLimitStore { return store }
+	}
+
+	for driver, store := range stores {
+		app := dojo.New(dojo.DefaultConfiguration{})
+		called := false
+		limiter := func(name string) dojo.MiddlewareFunc {
+			config := DefaultRateLimitConfig
+			config.Name = name
+			config.Limit = 1
+			config.Store = store()
+			return RateLimitWithConfig(config)
+		}
+		shared := fmt.Sprintf("%s-%d", driver, time.Now().UnixNano())
+		app.Route.Get("/a", protectedHandler(&called), limiter(""))
+		app.Route.Get("/b", protectedHandler(&called), limiter(""))
+		app.Route.Get("/c", protectedHandler(&called), limiter(shared))
+		app.Route.Get("/d", protectedHandler(&called), limiter(shared))
+
+		request := func(path string) int {
+			rec := httptest.NewRecorder()
+			app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			return rec.Code
+		}
+		if request("/a") != http.StatusOK || request("/b") != http.StatusOK {
+			t.Errorf("%s: expected the limiters without name to count their own requests", driver)
+		}
+		if request("/c") != http.StatusOK || request("/d") != http.StatusTooManyRequests {
+			t.Errorf("%s: expected the limiters with the same name to share the counter", driver)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore_Algorithms(t *testing.T) {
+	now := time.Unix(1000*60, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	bucket := RateLimitRule{Limit: 2, Period: time.Minute, Algorithm: TokenBucket}
+	for i := 0; i < 2; i++ {
+		if r, _ := store.Take(ctx, "bucket", bucket); !r.Allowed {
+			t.Fatalf("request %d: expected the bucket to have tokens", i+1)
+		}
+	}
+	r, _ := store.Take(ctx, "bucket", bucket)
+	if r.Allowed || r.RetryAfter != 30*time.Second {
+		t.Fatalf("expected the bucket to be empty for 30s, got %+v", r)
+	}
+	now = now.Add(30 * time.Second)
+	if r, _ := store.Take(ctx, "bucket", bucket); !r.Allowed {
+		t.Fatal("expected a token to be refilled")
+	}
+
+	now = time.Unix(2000*60, 0)
+	window := RateLimitRule{Limit: 4, Period: time.Minute, Algorithm: SlidingWindow}
+	for i := 0; i < 4; i++ {
+		if r, _ := store.Take(ctx, "window", window); !r.Allowed {
+			t.Fatalf("request %d: expected the window to allow it", i+1)
+		}
+	}
+	if r, _ := store.Take(ctx, "window", window); r.Allowed {
+		t.Fatal("expected the window to be full")
+	}
+
+	// Half of the previous window still counts
+	now = now.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if r, _ := store.Take(ctx, "window", window); !r.Allowed {
+			t.Fatalf("request %d: expected the sliding window to allow it", i+1)
+		}
+	}
+	if r, _ := store.Take(ctx, "window", window); r.Allowed {
+		t.Fatal("expected the weighted previous window to limit the requests")
+	}
+}
+
+func TestRedisRateLimitStore_Algorithms(t *testing.T) {
+	addr := os.Getenv("DOJO_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("DOJO_TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	store := NewRedisRateLimitStore(client)
+	store.Prefix = fmt.Sprintf("dojo:test:%d:", time.Now().UnixNano())
+	ctx := context.Background()
+	defer func() {
+		keys, _ := client.Keys(ctx, store.Prefix+"*").Result()
+		if len(keys) > 0 {
+			client.Del(ctx, keys...)
+		}
+	}()
+
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, SlidingWindow} {
+		rule := RateLimitRule{Limit: 2, Period: time.Minute, Algorithm: algorithm}
+		for i, remaining := range []int{1, 0} {
+			r, err := store.Take(ctx, string(algorithm), rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Allowed || r.Remaining != remaining {
+				t.Fatalf("%s request %d: expected %d remaining, got %+v", algorithm, i+1, remaining, r)
+			}
+		}
+		r, err := store.Take(ctx, string(algorithm), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Allowed || r.RetryAfter <= 0 {
+			t.Errorf("%s: expected the limit to be reached, got %+v", algorithm, r)
+		}
+	}
+}
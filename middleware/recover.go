@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"runtime"
+)
+
+type (
+	RecoverConfig struct {
+		Skipper Skipper
+
+		// StackSize is the size of the logged stack trace in bytes
+		StackSize int `yaml:"stack_size"`
+
+		// DisableStackAll only logs the stack of the panicking goroutine
+		DisableStackAll bool `yaml:"disable_stack_all"`
+
+		// DisablePrintStack doesn't log the stack trace
+		DisablePrintStack bool `yaml:"disable_print_stack"`
+	}
+)
+
+var (
+	DefaultRecoverConfig = RecoverConfig{
+		Skipper:   DefaultSkipper,
+		StackSize: 4 << 10,
+	}
+)
+
+// Recover turns the panics of the handlers into 500 errors for the error handler
+func Recover() dojo.MiddlewareFunc {
+	config := DefaultRecoverConfig
+	return RecoverWithConfig(config)
+}
+
+func RecoverWithConfig(config RecoverConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultRecoverConfig.Skipper
+	}
+	if config.StackSize == 0 {
+		config.StackSize = DefaultRecoverConfig.StackSize
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) (returnErr error) {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				if r == http.ErrAbortHandler {
+					// The server aborts the response without logging it
+					panic(r)
+				}
+				err, ok := r.(error)
+				if !ok {
+					err = fmt.Errorf("%v", r)
+				}
+
+				entry := context.Logger().WithError(err)
+				if !config.DisablePrintStack {
+					stack := make([]byte, config.StackSize)
+					stack = stack[:runtime.Stack(stack, !config.DisableStackAll)]
+					entry = entry.WithField("stack", string(stack))
+				}
+				entry.Error("panic recovered")
+
+				returnErr = &dojo.HTTPError{
+					Code:     http.StatusInternalServerError,
+					Message:  http.StatusText(http.StatusInternalServerError),
+					Internal: err,
+				}
+			}()
+
+			return next(context)
+		}
+	}
+}
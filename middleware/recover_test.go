@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGlobalMiddleware(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.Use(RequestIDWithConfig(RequestIDConfig{Generator: func() string { return "req-1" }}), Recover())
+
+	var routeID interface{}
+	app.Route.Get("/ok/{id}", func(ctx dojo.Context) error {
+		routeID = ctx.Value(dojo.RequestIDKey)
+		return ctx.JSON(http.StatusOK, ctx.Param("id"))
+	})
+	app.Route.Get("/panic", func(ctx dojo.Context) error {
+		panic("boom")
+	})
+
+	handler := app.Handler()
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/ok/7", http.StatusOK},
+		{"/panic", http.StatusInternalServerError},
+		{"/missing", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.path, c.code, rec.Code)
+		}
+		if rec.Header().Get(dojo.HeaderXRequestID) != "req-1" {
+			t.Errorf("%s: expected the global middleware to run", c.path)
+		}
+	}
+	if routeID != "req-1" {
+		t.Errorf("expected the route to share the context of the global middlewares, got %v", routeID)
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/zengineDev/dojo"
+	"strings"
+	"time"
+)
+
+// RegisterDefaults registers the middlewares of this package on the registry
+// of the app under the names they are used with on routers, unknown guards of
+// auth panic when the route is registered:
+//
+//	auth:api,web          Authentication with the guards
+//	guest                 Guest
+//	verified              Verified
+//	two_factor            TwoFactor
+//	can:ability           Authorize
+//	role:admin,editor     RequireRole
+//	permission:posts.edit RequirePermission
+//	abilities:read,write  TokenAuth
+//	throttle:60,1m[,key]  RateLimit, the key is ip, user or token
+//	timeout:5s            Timeout
+//	cache:5m[,tags]       Cache with the ttl and tags
+//	recover, csrf, cors, compress, etag, secure, request_id, logging
+func RegisterDefaults(app *dojo.Dojo) {
+	registry := app.MiddlewareRegistry
+	registry.RegisterFactory("auth", func(args ...string) (dojo.MiddlewareFunc, error) {
+		config := DefaultAuthenticationConfig
+		config.Auth = app.Auth
+		config.Guards = args
+		guards, err := authenticationGuards(config)
+		if err != nil {
+			return nil, err
+		}
+		return authentication(config, guards), nil
+	})
+	registry.RegisterFactory("can", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected one ability, got %d", len(args))
+		}
+		return Authorize(args[0]), nil
+	})
+	registry.RegisterFactory("role", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("expected at least one role")
+		}
+		return RequireRole(args...), nil
+	})
+	registry.RegisterFactory("permission", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("expected at least one permission")
+		}
+		return RequirePermission(args...), nil
+	})
+	registry.RegisterFactory("abilities", func(args ...string) (dojo.MiddlewareFunc, error) {
+		return TokenAuth(args...), nil
+	})
+	registry.RegisterFactory("timeout", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected a timeout like 5s")
+		}
+		timeout, err := time.ParseDuration(args[0])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", args[0])
+		}
+		return Timeout(timeout), nil
+	})
+	registry.RegisterFactory("cache", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) == 0 {
+			return Cache(DefaultCacheConfig.TTL), nil
+		}
+		config := DefaultCacheConfig
+		ttl, err := time.ParseDuration(args[0])
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid cache ttl %q", args[0])
+		}
+		config.TTL = ttl
+		config.Tags = args[1:]
+		return CacheWithConfig(config), nil
+	})
+	registry.RegisterFactory("throttle", func(args ...string) (dojo.MiddlewareFunc, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("expected <limit>,<period>[,<key>]")
+		}
+		config := DefaultRateLimitConfig
+		limit, period, err := ParseRateLimit(strings.Join(args[:2], ","))
+		if err != nil {
+			return nil, err
+		}
+		config.Limit = limit
+		config.Period = period
+		if len(args) == 3 {
+			switch args[2] {
+			case "ip", "user", "token":
+				config.Key = args[2]
+			default:
+				return nil, fmt.Errorf("unknown rate limit key %q", args[2])
+			}
+		}
+		return RateLimitWithConfig(config), nil
+	})
+
+	registry.Register("recover", Recover())
+	registry.Register("guest", Guest())
+	registry.Register("verified", Verified())
+	registry.Register("two_factor", TwoFactor())
+	registry.Register("csrf", CSRF())
+	registry.Register("cors", CORS())
+	registry.Register("compress", Compress())
+	registry.Register("etag", ETag())
+	registry.Register("secure", Secure())
+	registry.Register("request_id", RequestID())
+	registry.Register("logging", Logging())
+}
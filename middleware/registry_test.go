@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterDefaults_Throttle(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	RegisterDefaults(app)
+
+	app.Route.Use("throttle:1,1")
+	called := false
+	app.Route.Get("/search", protectedHandler(&called))
+
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, code := range codes {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+		if rec.Code != code {
+			t.Fatalf("request %d: expected %d, got %d", i+1, code, rec.Code)
+		}
+	}
+
+	if _, err := app.MiddlewareRegistry.Resolve("throttle:many"); err == nil {
+		t.Error("expected an invalid throttle spec to fail")
+	}
+}
+
+func TestRegisterDefaults_LogsPanics(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	hook := test.NewLocal(app.Logger)
+	RegisterDefaults(app)
+
+	app.Route.Use("recover")
+	app.Route.Use("logging")
+	app.Route.Get("/panic", func(ctx dojo.Context) error {
+		panic("boom")
+	})
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a 500, got %d", rec.Code)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "request_log" || entry.Data["status"] != http.StatusInternalServerError {
+		t.Errorf("expected the panicking request to be logged, got %+v", entry)
+	}
+}
+
+func TestRegisterDefaults_ThrottleIsSharedByTheRoutes(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	RegisterDefaults(app)
+
+	app.Route.RouteGroup("/api", func(router *dojo.Router) {
+		router.Use("throttle:2,1")
+		called := false
+		router.Get("/users", protectedHandler(&called))
+		router.Get("/posts", protectedHandler(&called))
+	})
+
+	codes := map[string]int{"/api/users": http.StatusOK, "/api/posts": http.StatusOK}
+	for path, code := range codes {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Fatalf("%s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the limit of the group to be used up, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/zengineDev/dojo"
+)
+
+type (
+	RequestIDConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		// Generator returns a new id, the default generates uuids
+		Generator func() string `yaml:"-"`
+
+		// Header carries the id of the request and the response
+		Header string `yaml:"header"`
+
+		// TrustedProxies are the ips and cidr ranges whose request ids are
+		// kept, every other request gets a new id.
+		TrustedProxies []string `yaml:"trusted_proxies"`
+	}
+)
+
+// maxRequestIDLength limits the ids that are propagated from proxies
+const maxRequestIDLength = 128
+
+var (
+	DefaultRequestIDConfig = RequestIDConfig{
+		Skipper:   DefaultSkipper,
+		Generator: generateRequestID,
+		Header:    dojo.HeaderXRequestID,
+	}
+)
+
+func RequestID() dojo.MiddlewareFunc {
+	config := DefaultRequestIDConfig
+	return RequestIDWithConfig(config)
+}
+
+func RequestIDWithConfig(config RequestIDConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultRequestIDConfig.Skipper
+	}
+	if config.Generator == nil {
+		config.Generator = DefaultRequestIDConfig.Generator
+	}
+	if config.Header == "" {
+		config.Header = DefaultRequestIDConfig.Header
+	}
+
+	proxies := newTrustedProxies(config.TrustedProxies)
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			req := context.Request()
+			id := ""
+			if proxies.trusts(req) {
+				id = req.Header.Get(config.Header)
+			}
+			if !validRequestID(id) {
+				id = config.Generator()
+			}
+
+			context.Set(dojo.RequestIDKey, id)
+			context.Response().Header().Set(config.Header, id)
+
+			return next(context)
+		}
+	}
+}
+
+func generateRequestID() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// validRequestID only accepts printable ascii, so the ids can't forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	hook := test.NewLocal(app.Logger)
+	config := DefaultRequestIDConfig
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	config.Generator = func() string { return "generated" }
+	app.Route.GetWithName("/orders", "orders.index", func(ctx dojo.Context) error {
+		ctx.Logger().Info("listing orders")
+		return ctx.NoContent(http.StatusOK)
+	}, RequestIDWithConfig(config))
+
+	cases := []struct {
+		name   string
+		remote string
+		header string
+		expect string
+	}{
+		{"generated", "203.0.113.7:4000", "", "generated"},
+		{"untrusted client", "203.0.113.7:4000", "forged", "generated"},
+		{"trusted proxy", "10.0.0.2:4000", "abc-123", "abc-123"},
+		{"invalid id", "10.0.0.2:4000", "bad id\n", "generated"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hook.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			req.RemoteAddr = c.remote
+			if c.header != "" {
+				req.Header.Set(dojo.HeaderXRequestID, c.header)
+			}
+			rec := httptest.NewRecorder()
+			app.Route.GetMux().ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(dojo.HeaderXRequestID); got != c.expect {
+				t.Fatalf("expected the id %q, got %q", c.expect, got)
+			}
+			entry := hook.LastEntry()
+			if entry == nil || entry.Data["request_id"] != c.expect || entry.Data["route"] != "orders.index" || entry.Data["method"] != http.MethodGet {
+				t.Errorf("expected the log entry to carry the request, got %+v", entry)
+			}
+		})
+	}
+}
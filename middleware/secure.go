@@ -1 +1,219 @@
 package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/zengineDev/dojo"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type (
+	SecureConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+
+		// SecureConfig are the settings of the secure section of the configuration
+		dojo.SecureConfig `yaml:",inline"`
+	}
+)
+
+const cspNoncePlaceholder = "{nonce}"
+
+var (
+	DefaultSecureConfig = SecureConfig{
+		Skipper: DefaultSkipper,
+		SecureConfig: dojo.SecureConfig{
+			XContentTypeOptions: "nosniff",
+			XFrameOptions:       "SAMEORIGIN",
+			ReferrerPolicy:      "strict-origin-when-cross-origin",
+		},
+	}
+
+	errNoHTTPSHost = errors.New("the https redirect needs the https host or the domain of the app")
+)
+
+// Secure uses the secure section of the configuration of the app, the
+// default headers are sent when they are not configured.
+func Secure() dojo.MiddlewareFunc {
+	return func(next dojo.Handler) dojo.Handler {
+		var once sync.Once
+		var h dojo.Handler
+		return func(context dojo.Context) error {
+			once.Do(func() {
+				config := DefaultSecureConfig
+				config.SecureConfig = context.Dojo().Configuration.Secure
+				if config.XContentTypeOptions == "" {
+					config.XContentTypeOptions = DefaultSecureConfig.XContentTypeOptions
+				}
+				if config.XFrameOptions == "" {
+					config.XFrameOptions = DefaultSecureConfig.XFrameOptions
+				}
+				if config.ReferrerPolicy == "" {
+					config.ReferrerPolicy = DefaultSecureConfig.ReferrerPolicy
+				}
+				h = SecureWithConfig(config)(next)
+			})
+			return h(context)
+		}
+	}
+}
+
+func SecureWithConfig(config SecureConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultSecureConfig.Skipper
+	}
+
+	proxies := newTrustedProxies(config.TrustedProxies)
+
+	hsts := ""
+	if config.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", config.HSTSMaxAge)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	policy := config.ContentSecurityPolicy
+	if policy != "" && config.CSPReportURI != "" {
+		policy = strings.TrimRight(strings.TrimSpace(policy), ";") + "; report-uri " + config.CSPReportURI
+	}
+	useNonce := strings.Contains(policy, cspNoncePlaceholder)
+
+	cspHeader := dojo.HeaderContentSecurityPolicy
+	if config.CSPReportOnly {
+		cspHeader = dojo.HeaderContentSecurityPolicyReportOnly
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			req := context.Request()
+			https := proxies.isHTTPS(req)
+
+			if config.HTTPSRedirect && !https {
+				// The host of the request is not trusted, it would make an open redirect
+				host := config.HTTPSHost
+				if host == "" {
+					host = domainHost(context.Dojo().Configuration.App.Domain)
+				}
+				if host == "" {
+					return errNoHTTPSHost
+				}
+				code := http.StatusMovedPermanently
+				if req.Method != http.MethodGet && req.Method != http.MethodHead {
+					code = http.StatusPermanentRedirect
+				}
+				http.Redirect(context.Response(), req, "https://"+host+req.URL.RequestURI(), code)
+				return nil
+			}
+
+			header := context.Response().Header()
+			setHeader(header, dojo.HeaderXContentTypeOptions, config.XContentTypeOptions)
+			setHeader(header, dojo.HeaderXFrameOptions, config.XFrameOptions)
+			setHeader(header, dojo.HeaderReferrerPolicy, config.ReferrerPolicy)
+			setHeader(header, dojo.HeaderPermissionsPolicy, config.PermissionsPolicy)
+			setHeader(header, dojo.HeaderCrossOriginOpenerPolicy, config.CrossOriginOpenerPolicy)
+			setHeader(header, dojo.HeaderCrossOriginEmbedderPolicy, config.CrossOriginEmbedderPolicy)
+			if useNonce {
+				nonce, err := newCSPNonce()
+				if err != nil {
+					return err
+				}
+				context.Set(dojo.CSPNonceKey, nonce)
+				header.Set(cspHeader, strings.ReplaceAll(policy, cspNoncePlaceholder, nonce))
+			} else {
+				setHeader(header, cspHeader, policy)
+			}
+			if https {
+				setHeader(header, dojo.HeaderStrictTransportSecurity, hsts)
+			}
+
+			return next(context)
+		}
+	}
+}
+
+// domainHost returns the host of the domain of the app, it may be configured with or without scheme
+func domainHost(domain string) string {
+	if !strings.Contains(domain, "://") {
+		return strings.TrimRight(domain, "/")
+	}
+	u, err := url.Parse(domain)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// CSPReport is a handler for the report-uri of the content security policy,
+// it logs the reported violations.
+func CSPReport(ctx dojo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, 64*1024))
+	if err != nil {
+		return dojo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var reports []map[string]interface{}
+	var legacy struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+		reports = append(reports, legacy.Report)
+	} else {
+		// The Reporting API sends a list of reports with the violation in the body
+		var list []struct {
+			Type string                 `json:"type"`
+			Body map[string]interface{} `json:"body"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return dojo.NewHTTPError(http.StatusBadRequest, "invalid csp report")
+		}
+		for _, r := range list {
+			if r.Type == "csp-violation" {
+				reports = append(reports, r.Body)
+			}
+		}
+	}
+
+	for _, report := range reports {
+		// The report is nested, its keys can't overwrite the fields of the entry
+		ctx.Dojo().Logger.WithFields(logrus.Fields{
+			"event":     "csp_violation",
+			"remote_ip": ctx.RealIP(),
+			"report":    report,
+		}).Warn("content security policy violation")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func setHeader(header http.Header, name, value string) {
+	if value != "" {
+		header.Set(name, value)
+	}
+}
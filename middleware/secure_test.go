@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/steinfletcher/apitest"
+	"github.com/zengineDev/dojo"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecure_DefaultHeaders(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	called := false
+	app.Route.Get("/", protectedHandler(&called), Secure())
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Get("/").
+		Expect(t).
+		Header(dojo.HeaderXContentTypeOptions, "nosniff").
+		Header(dojo.HeaderXFrameOptions, "SAMEORIGIN").
+		Header(dojo.HeaderReferrerPolicy, "strict-origin-when-cross-origin").
+		HeaderNotPresent(dojo.HeaderStrictTransportSecurity).
+		HeaderNotPresent(dojo.HeaderContentSecurityPolicy).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestSecure_FromYAML(t *testing.T) {
+	var config dojo.DefaultConfiguration
+	err := yaml.Unmarshal([]byte(`
+dojo:
+  domain: https://example.com
+secure:
+  hsts_max_age: 31536000
+  hsts_include_subdomains: true
+  hsts_preload: true
+  content_security_policy: "default-src 'self'"
+  csp_report_only: true
+  permissions_policy: "geolocation=()"
+  cross_origin_opener_policy: same-origin
+  https_redirect: true
+  trusted_proxies: ["10.0.0.0/8"]
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := dojo.New(config)
+	called := false
+	app.Route.Get("/account", protectedHandler(&called), Secure())
+
+	// Forwarded headers of untrusted clients are ignored
+	// and the redirect goes to the domain of the app, not to the host of the request
+	req := httptest.NewRequest(http.MethodGet, "http://attacker.test/account?tab=1", nil)
+	req.RemoteAddr = "203.0.113.7:4000"
+	req.Header.Set(dojo.HeaderXForwardedProto, "https")
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get(dojo.HeaderLocation) != "https://example.com/account?tab=1" {
+		t.Fatalf("expected a redirect to https, got %d %s", rec.Code, rec.Header().Get(dojo.HeaderLocation))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/account", nil)
+	req.RemoteAddr = "10.1.2.3:4000"
+	req.Header.Set(dojo.HeaderXForwardedProto, "https")
+	rec = httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !called {
+		t.Fatalf("expected the request of the trusted proxy to pass, got %d", rec.Code)
+	}
+	expect := map[string]string{
+		dojo.HeaderStrictTransportSecurity:         "max-age=31536000; includeSubDomains; preload",
+		dojo.HeaderContentSecurityPolicyReportOnly: "default-src 'self'",
+		dojo.HeaderPermissionsPolicy:               "geolocation=()",
+		dojo.HeaderCrossOriginOpenerPolicy:         "same-origin",
+		dojo.HeaderXFrameOptions:                   "SAMEORIGIN",
+	}
+	for name, value := range expect {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, got)
+		}
+	}
+	if rec.Header().Get(dojo.HeaderContentSecurityPolicy) != "" {
+		t.Error("the enforced policy must not be sent in report only mode")
+	}
+}
+
+func TestSecure_RedirectNeedsAHost(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	config := DefaultSecureConfig
+	config.HTTPSRedirect = true
+	called := false
+	app.Route.Get("/", protectedHandler(&called), SecureWithConfig(config))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://attacker.test/", nil))
+	if rec.Code != http.StatusInternalServerError || called || rec.Header().Get(dojo.HeaderLocation) != "" {
+		t.Errorf("expected no redirect without a host, got %d %s", rec.Code, rec.Header().Get(dojo.HeaderLocation))
+	}
+}
+
+func TestSecure_CSPNonce(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	config := DefaultSecureConfig
+	config.ContentSecurityPolicy = "script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'"
+	config.CSPReportURI = "/csp-report"
+
+	var nonces []string
+	app.Route.Get("/", func(ctx dojo.Context) error {
+		nonces = append(nonces, ctx.CSPNonce())
+		return ctx.NoContent(http.StatusOK)
+	}, SecureWithConfig(config))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		nonce := nonces[i]
+		expect := "script-src 'self' 'nonce-" + nonce + "'; style-src 'self' 'nonce-" + nonce + "'; report-uri /csp-report"
+		if nonce == "" || rec.Header().Get(dojo.HeaderContentSecurityPolicy) != expect {
+			t.Fatalf("expected the policy %q, got %q", expect, rec.Header().Get(dojo.HeaderContentSecurityPolicy))
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("every request needs its own nonce")
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	app.Route.Post("/csp-report", CSPReport)
+	hook := test.NewLocal(app.Logger)
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Post("/csp-report").
+		Header(dojo.HeaderContentType, "application/csp-report").
+		Body(`{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src","remote_ip":"10.0.0.1"}}`).
+		Expect(t).
+		Status(http.StatusNoContent).
+		End()
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["event"] != "csp_violation" {
+		t.Fatalf("expected the violation to be logged, got %v", entry)
+	}
+	if entry.Data["remote_ip"] == "10.0.0.1" {
+		t.Error("the report must not overwrite the fields of the entry")
+	}
+	if report, ok := entry.Data["report"].(map[string]interface{}); !ok || report["violated-directive"] != "script-src" {
+		t.Errorf("expected the report under its own field, got %v", entry.Data)
+	}
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Post("/csp-report").
+		Header(dojo.HeaderContentType, "application/reports+json").
+		Body(`[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"style-src"}}]`).
+		Expect(t).
+		Status(http.StatusNoContent).
+		End()
+
+	apitest.New().
+		Handler(app.Route.GetMux()).
+		Post("/csp-report").
+		Body(`not json`).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
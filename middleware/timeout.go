@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	stdcontext "context"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	TimeoutConfig struct {
+		Skipper Skipper
+
+		// Timeout of the handler, it has to be shorter than the WriteTimeout of the server
+		Timeout time.Duration `yaml:"timeout"`
+
+		// OnTimeout writes the response of a timed out request, the
+		// ErrServiceUnavailable error is returned when it is nil.
+		OnTimeout dojo.Handler `yaml:"-"`
+	}
+
+	// timeoutWriter buffers the response of the handler, so it can be
+	// dropped when the handler finishes after the timeout.
+	timeoutWriter struct {
+		mu          sync.Mutex
+		header      http.Header
+		body        bytes.Buffer
+		code        int
+		wroteHeader bool
+		timedOut    bool
+	}
+)
+
+var (
+	DefaultTimeoutConfig = TimeoutConfig{
+		Skipper: DefaultSkipper,
+		Timeout: 25 * time.Second,
+	}
+)
+
+// Timeout cancels the context of the request after the timeout and answers
+// with 503, the database queries and http calls that use the context stop.
+func Timeout(timeout time.Duration) dojo.MiddlewareFunc {
+	config := DefaultTimeoutConfig
+	config.Timeout = timeout
+	return TimeoutWithConfig(config)
+}
+
+func TimeoutWithConfig(config TimeoutConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultTimeoutConfig.Skipper
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeoutConfig.Timeout
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			deadline, cancel := stdcontext.WithTimeout(context.Request().Context(), config.Timeout)
+			defer cancel()
+
+			res := context.Response()
+			tw := &timeoutWriter{header: res.Header().Clone(), code: http.StatusOK}
+
+			// The handler runs with its own response and a copy of the session,
+			// the ones of the request are only used by this goroutine.
+			hctx := dojo.ContextWithResponse(context, tw)
+			hctx.SetRequest(context.Request().WithContext(deadline))
+
+			done := make(chan error, 1)
+			panics := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panics <- p
+					}
+				}()
+				done <- next(hctx)
+			}()
+
+			select {
+			case p := <-panics:
+				panic(p)
+			case err := <-done:
+				// The handler finished in time, its session changes are kept
+				if s := hctx.Session(); s != nil && s.Session != nil {
+					context.Session().Session.Values = s.Session.Values
+				}
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				header := res.Header()
+				for k := range header {
+					if _, ok := tw.header[k]; !ok {
+						header.Del(k)
+					}
+				}
+				for k, v := range tw.header {
+					header[k] = v
+				}
+				if tw.wroteHeader || tw.body.Len() > 0 {
+					res.WriteHeader(tw.code)
+					if _, werr := res.Write(tw.body.Bytes()); werr != nil && err == nil {
+						err = werr
+					}
+				}
+				return err
+			case <-deadline.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+
+				context.Logger().WithField("timeout", config.Timeout.String()).Warn("request timed out")
+				if config.OnTimeout != nil {
+					return config.OnTimeout(context)
+				}
+				return dojo.ErrServiceUnavailable
+			}
+		}
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.code = code
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	finished := make(chan struct{})
+
+	app.Route.Get("/fast", func(ctx dojo.Context) error {
+		ctx.Response().Header().Set("X-Handler", "fast")
+		return ctx.JSON(http.StatusCreated, "done")
+	}, Timeout(time.Second))
+	app.Route.Get("/cancelled", func(ctx dojo.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, Timeout(20*time.Millisecond))
+	app.Route.Get("/late", func(ctx dojo.Context) error {
+		defer close(finished)
+		time.Sleep(50 * time.Millisecond)
+		return ctx.JSON(http.StatusOK, "too late")
+	}, TimeoutWithConfig(TimeoutConfig{
+		Timeout: 10 * time.Millisecond,
+		OnTimeout: func(ctx dojo.Context) error {
+			return ctx.JSON(http.StatusGatewayTimeout, "timeout")
+		},
+	}))
+
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/fast", http.StatusCreated, `{"data":"done"}`},
+		{"/cancelled", http.StatusServiceUnavailable, ""},
+		{"/late", http.StatusGatewayTimeout, `{"data":"timeout"}`},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.path, c.code, rec.Code)
+		}
+		if c.body != "" && rec.Body.String() != c.body {
+			t.Errorf("%s: unexpected body %q", c.path, rec.Body.String())
+		}
+		if c.path == "/fast" && rec.Header().Get("X-Handler") != "fast" {
+			t.Errorf("expected the headers of the handler")
+		}
+		if c.path == "/late" {
+			// The late response of the handler must be dropped
+			<-finished
+			if rec.Body.String() != c.body {
+				t.Errorf("expected a single response, got %q", rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestTimeout_KeepsTheSessionCookie(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{
+		Session: dojo.SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	app.Route.Get("/visit", func(ctx dojo.Context) error {
+		ctx.Session().Set("visited", true)
+		if err := ctx.Session().Save(); err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, "visited")
+	}, Timeout(time.Second))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/visit", nil))
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "dojo_session" {
+			return
+		}
+	}
+	t.Errorf("expected the session cookie, got %v", rec.Header())
+}
+
+func TestTimeout_CopiesTheSession(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{
+		Session: dojo.SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	finished := make(chan struct{})
+	var late interface{}
+
+	app.Route.Get("/late", func(ctx dojo.Context) error {
+		defer close(finished)
+		time.Sleep(30 * time.Millisecond)
+		ctx.Session().Set("late", true)
+		return ctx.Session().Save()
+	}, TimeoutWithConfig(TimeoutConfig{
+		Timeout: 10 * time.Millisecond,
+		OnTimeout: func(ctx dojo.Context) error {
+			<-finished
+			late = ctx.Session().Get("late")
+			return ctx.NoContent(http.StatusGatewayTimeout)
+		},
+	}))
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/late", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected the timeout response, got %d", rec.Code)
+	}
+	if late != nil {
+		t.Error("the session changes of a timed out handler must not reach the request")
+	}
+}
+
+type wrappedContext struct {
+	dojo.Context
+}
+
+func TestTimeout_PanicsForAnUnknownContext(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	handler := Timeout(time.Second)(func(ctx dojo.Context) error {
+		return nil
+	})
+	app.Route.Get("/", func(ctx dojo.Context) error {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a context that can't be copied")
+			}
+		}()
+		return handler(wrappedContext{ctx})
+	})
+	app.Route.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"strings"
+)
+
+type (
+	TokenAuthConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		// Abilities the token needs all of
+		Abilities []string
+	}
+)
+
+var (
+	DefaultTokenAuthConfig = TokenAuthConfig{
+		Skipper: DefaultSkipper,
+	}
+
+	ErrTokenMissing = dojo.NewHTTPError(http.StatusUnauthorized, "missing access token")
+	ErrTokenInvalid = dojo.NewHTTPError(http.StatusUnauthorized, "invalid or expired access token")
+)
+
+func TokenAuth(abilities ...string) dojo.MiddlewareFunc {
+	config := DefaultTokenAuthConfig
+	config.Abilities = abilities
+	return TokenAuthWithConfig(config)
+}
+
+func TokenAuthWithConfig(config TokenAuthConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultTokenAuthConfig.Skipper
+	}
+
+	extractor := tokenFromHeader(dojo.HeaderAuthorization, "Bearer")
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			plain := extractor(context)
+			if plain == "" {
+				context.Response().Header().Set(dojo.HeaderWWWAuthenticate, "Bearer")
+				return ErrTokenMissing
+			}
+
+			err := context.Dojo().Auth.AuthenticateAccessToken(context, plain)
+			if errors.Is(err, dojo.ErrAccessTokenInvalid) || errors.Is(err, dojo.ErrAccessTokenExpired) {
+				context.Response().Header().Set(dojo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return &dojo.HTTPError{Code: ErrTokenInvalid.Code, Message: ErrTokenInvalid.Message, Internal: err}
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, ability := range config.Abilities {
+				if !context.TokenCan(ability) {
+					context.Response().Header().Set(dojo.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="`+strings.Join(config.Abilities, " ")+`"`)
+					return dojo.ErrForbidden
+				}
+			}
+
+			return next(context)
+		}
+	}
+}
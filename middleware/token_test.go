@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type tokenTestStore struct {
+	tokens map[uuid.UUID]dojo.PersonalAccessToken
+}
+
+func (s *tokenTestStore) Create(ctx context.Context, token dojo.PersonalAccessToken) error {
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *tokenTestStore) Find(ctx context.Context, id uuid.UUID) (dojo.PersonalAccessToken, error) {
+	token, ok := s.tokens[id]
+	if !ok {
+		return token, dojo.ErrAccessTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *tokenTestStore) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return nil
+}
+
+func (s *tokenTestStore) Revoke(ctx context.Context, id uuid.UUID) error {
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *tokenTestStore) ForUser(ctx context.Context, userID string) ([]dojo.PersonalAccessToken, error) {
+	return nil, nil
+}
+
+type tokenTestUsers struct{}
+
+func (tokenTestUsers) RetrieveByID(ctx context.Context, id string) (dojo.Authenticable, error) {
+	return &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.FromStringOrNil(id))}, nil
+}
+
+func TestTokenAuth(t *testing.T) {
+	app := dojo.New(dojo.DefaultConfiguration{})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	app.Auth.Users = tokenTestUsers{}
+	app.Auth.AccessTokens = &tokenTestStore{tokens: map[uuid.UUID]dojo.PersonalAccessToken{}}
+
+	owner := &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	plain, _, err := app.Auth.CreateAccessToken(context.Background(), owner, "ci", []string{"posts.read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Route.Get("/posts", func(ctx dojo.Context) error {
+		return ctx.JSON(http.StatusOK, ctx.Dojo().Auth.GetAuthUser(ctx).Identifier.String())
+	}, TokenAuth("posts.read"))
+	app.Route.Get("/users", func(ctx dojo.Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	}, TokenAuth("users.delete"))
+
+	cases := []struct {
+		path          string
+		authorization string
+		code          int
+		challenge     string
+	}{
+		{"/posts", "", http.StatusUnauthorized, "Bearer"},
+		{"/posts", "Bearer " + uuid.Must(uuid.NewV4()).String() + "|secret", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"/users", "Bearer " + plain, http.StatusForbidden, `Bearer error="insufficient_scope", scope="users.delete"`},
+		{"/posts", "Bearer " + plain, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.authorization != "" {
+			req.Header.Set(dojo.HeaderAuthorization, c.authorization)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		if rec.Code != c.code || rec.Header().Get(dojo.HeaderWWWAuthenticate) != c.challenge {
+			t.Errorf("%s %q: expected %d %q, got %d %q", c.path, c.authorization, c.code, c.challenge, rec.Code, rec.Header().Get(dojo.HeaderWWWAuthenticate))
+		}
+		if c.code == http.StatusOK && rec.Body.String() != `{"data":"`+owner.Identifier.String()+`"}` {
+			t.Errorf("expected the owner of the token, got %s", rec.Body.String())
+		}
+	}
+}
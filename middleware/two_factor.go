@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+)
+
+type (
+	TwoFactorConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		// ChallengePath is where users with a pending second factor are redirected to
+		ChallengePath string
+		// EnrollPath is where users without two factor authentication are
+		// redirected to, enrollment is not enforced when it is empty.
+		EnrollPath string
+	}
+)
+
+var (
+	DefaultTwoFactorConfig = TwoFactorConfig{
+		Skipper:       DefaultSkipper,
+		ChallengePath: "/two-factor-challenge",
+	}
+)
+
+func TwoFactor() dojo.MiddlewareFunc {
+	config := DefaultTwoFactorConfig
+	return TwoFactorWithConfig(config)
+}
+
+func TwoFactorWithConfig(config TwoFactorConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultTwoFactorConfig.Skipper
+	}
+	if config.ChallengePath == "" {
+		config.ChallengePath = DefaultTwoFactorConfig.ChallengePath
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			auth := context.Dojo().Auth
+			switch auth.LoginState(context) {
+			case dojo.LoginStateTwoFactorPending:
+				if dojo.ExpectsJSON(context) {
+					return dojo.NewHTTPError(http.StatusUnauthorized, "two factor authentication required")
+				}
+				http.Redirect(context.Response(), context.Request(), config.ChallengePath, http.StatusFound)
+				return nil
+			case dojo.LoginStateAuthenticated:
+				if config.EnrollPath == "" {
+					return next(context)
+				}
+				user := auth.GetAuthUser(context)
+				if tf, ok := user.GetAuthData().(dojo.TwoFactorAuthenticable); ok && !tf.TwoFactorEnabled() {
+					if dojo.ExpectsJSON(context) {
+						return dojo.NewHTTPError(http.StatusForbidden, "two factor authentication enrollment required")
+					}
+					http.Redirect(context.Response(), context.Request(), config.EnrollPath, http.StatusFound)
+					return nil
+				}
+			}
+
+			return next(context)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/gob"
+	"github.com/gofrs/uuid"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type twoFactorTestData struct {
+	Enabled bool
+}
+
+func (d twoFactorTestData) TwoFactorEnabled() bool {
+	return d.Enabled
+}
+
+func TestTwoFactor(t *testing.T) {
+	gob.Register(twoFactorTestData{})
+	app := newAuthApp()
+	app.Route.Get("/login-pending", func(ctx dojo.Context) error {
+		return ctx.Dojo().Auth.LoginPendingTwoFactor(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4()))})
+	})
+	app.Route.Get("/login-enrolled", func(ctx dojo.Context) error {
+		enabled := ctx.Request().URL.Query().Get("enabled") == "1"
+		return ctx.Dojo().Auth.Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: twoFactorTestData{Enabled: enabled}})
+	})
+	called := false
+	app.Route.Get("/account", protectedHandler(&called), TwoFactor())
+	app.Route.Get("/settings", protectedHandler(&called), TwoFactorWithConfig(TwoFactorConfig{EnrollPath: "/two-factor/enroll"}))
+
+	login := func(path string) []*http.Cookie {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Result().Cookies()
+	}
+	get := func(path string, cookies []*http.Cookie, accept string) *httptest.ResponseRecorder {
+		called = false
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if accept != "" {
+			req.Header.Set(dojo.HeaderAccept, accept)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	pending := login("/login-pending")
+	rec := get("/account", pending, "")
+	if rec.Code != http.StatusFound || rec.Header().Get(dojo.HeaderLocation) != "/two-factor-challenge" || called {
+		t.Fatalf("expected a redirect to the challenge, got %d %v", rec.Code, rec.Header())
+	}
+	if rec = get("/account", pending, dojo.MIMEApplicationJSON); rec.Code != http.StatusUnauthorized || called {
+		t.Fatalf("expected json requests to be unauthorized, got %d", rec.Code)
+	}
+
+	notEnrolled := login("/login-enrolled?enabled=0")
+	if rec = get("/account", notEnrolled, ""); rec.Code != http.StatusOK || !called {
+		t.Errorf("expected the enrollment to be optional without an enroll path, got %d", rec.Code)
+	}
+	rec = get("/settings", notEnrolled, "")
+	if rec.Code != http.StatusFound || rec.Header().Get(dojo.HeaderLocation) != "/two-factor/enroll" || called {
+		t.Errorf("expected a redirect to the enrollment, got %d %v", rec.Code, rec.Header())
+	}
+	if rec = get("/settings", login("/login-enrolled?enabled=1"), ""); rec.Code != http.StatusOK || !called {
+		t.Errorf("expected enrolled users to pass, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"github.com/zengineDev/dojo"
+	"net/http"
+)
+
+type (
+	VerifiedConfig struct {
+		Skipper    Skipper
+		BeforeFunc BeforeFunc
+		// RedirectPath is where users with an unverified email address are redirected to
+		RedirectPath string
+	}
+)
+
+var (
+	DefaultVerifiedConfig = VerifiedConfig{
+		Skipper:      DefaultSkipper,
+		RedirectPath: "/email/verification-notice",
+	}
+
+	ErrEmailNotVerified = dojo.NewHTTPError(http.StatusForbidden, "your email address is not verified")
+)
+
+// Verified only lets users with a verified email address pass. It has to be
+// used after the authentication middleware.
+func Verified() dojo.MiddlewareFunc {
+	config := DefaultVerifiedConfig
+	return VerifiedWithConfig(config)
+}
+
+func VerifiedWithConfig(config VerifiedConfig) dojo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultVerifiedConfig.Skipper
+	}
+	if config.RedirectPath == "" {
+		config.RedirectPath = DefaultVerifiedConfig.RedirectPath
+	}
+
+	return func(next dojo.Handler) dojo.Handler {
+		return func(context dojo.Context) error {
+			if config.Skipper(context) {
+				return next(context)
+			}
+
+			if config.BeforeFunc != nil {
+				config.BeforeFunc(context)
+			}
+
+			user := context.Dojo().Auth.GetAuthUser(context)
+			if user.IsGuest() {
+				return dojo.ErrUnauthorized
+			}
+			if dojo.HasVerifiedEmail(&user) {
+				return next(context)
+			}
+
+			if dojo.ExpectsJSON(context) {
+				return ErrEmailNotVerified
+			}
+
+			http.Redirect(context.Response(), context.Request(), config.RedirectPath, http.StatusFound)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/gob"
+	"github.com/gofrs/uuid"
+	"github.com/zengineDev/dojo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type verifiedTestData struct {
+	Verified bool
+}
+
+func (d verifiedTestData) HasVerifiedEmail() bool {
+	return d.Verified
+}
+
+func TestVerified(t *testing.T) {
+	gob.Register(verifiedTestData{})
+	app := newAuthApp()
+	app.Route.Get("/login-verified", func(ctx dojo.Context) error {
+		verified := ctx.Request().URL.Query().Get("verified") == "1"
+		return ctx.Dojo().Auth.Login(ctx, &dojo.AuthUser{Identifier: dojo.UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: verifiedTestData{Verified: verified}})
+	})
+	called := false
+	app.Route.Get("/billing", protectedHandler(&called), Verified())
+
+	login := func(verified string) []*http.Cookie {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login-verified?verified="+verified, nil))
+		return rec.Result().Cookies()
+	}
+	billing := func(cookies []*http.Cookie, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/billing", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if accept != "" {
+			req.Header.Set(dojo.HeaderAccept, accept)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := billing(nil, ""); rec.Code != http.StatusUnauthorized || called {
+		t.Fatalf("expected guests to be unauthorized, got %d", rec.Code)
+	}
+
+	unverified := login("0")
+	rec := billing(unverified, "")
+	if rec.Code != http.StatusFound || rec.Header().Get(dojo.HeaderLocation) != DefaultVerifiedConfig.RedirectPath || called {
+		t.Fatalf("expected a redirect to the notice, got %d %v", rec.Code, rec.Header())
+	}
+	if rec = billing(unverified, dojo.MIMEApplicationJSON); rec.Code != http.StatusForbidden || called {
+		t.Fatalf("expected json requests to be forbidden, got %d", rec.Code)
+	}
+
+	if rec = billing(login("1"), ""); rec.Code != http.StatusOK || !called {
+		t.Errorf("expected verified users to pass, got %d", rec.Code)
+	}
+}
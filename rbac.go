@@ -0,0 +1,139 @@
+package dojo
+
+import (
+	"context"
+	"strings"
+)
+
+const userAccessContextKey = "auth_access"
+
+// HasRoles can be implemented by the user or its data to provide the roles
+// and permissions directly instead of loading them from the RoleProvider.
+type HasRoles interface {
+	GetRoles() []string
+	GetPermissions() []string
+}
+
+// RoleProvider loads the roles and permissions of a user. The permissions
+// include the ones granted through the roles of the user.
+type RoleProvider interface {
+	RolesFor(ctx context.Context, userID string) ([]string, error)
+	PermissionsFor(ctx context.Context, userID string) ([]string, error)
+}
+
+// UserAccess holds the roles and permissions of a user.
+type UserAccess struct {
+	Roles       []string
+	Permissions []string
+}
+
+// HasRole reports if the access contains any of the roles
+func (a UserAccess) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, r := range a.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPermission reports if any of the permissions matches the permission,
+// wildcards like "posts.*" are supported.
+func (a UserAccess) HasPermission(permission string) bool {
+	for _, p := range a.Permissions {
+		if MatchPermission(p, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPermission reports if the pattern grants the permission. A "*" segment
+// matches the rest of the permission, so "posts.*" grants "posts.create" and
+// "posts.comments.delete" and "*" grants everything.
+func MatchPermission(pattern, permission string) bool {
+	if pattern == permission || pattern == "*" {
+		return true
+	}
+	if !strings.HasSuffix(pattern, ".*") {
+		return false
+	}
+	return strings.HasPrefix(permission, strings.TrimSuffix(pattern, "*"))
+}
+
+type cachedUserAccess struct {
+	userID string
+	access UserAccess
+}
+
+// Access returns the roles and permissions of the authenticated user,
+// they are loaded once per request.
+func (auth *Authentication) Access(ctx Context) (UserAccess, error) {
+	user := auth.GetAuthUser(ctx)
+	if user.IsGuest() {
+		return UserAccess{}, nil
+	}
+	userID := user.GetAuthIdentifier().String()
+
+	if cached, ok := ctx.Value(userAccessContextKey).(cachedUserAccess); ok && cached.userID == userID {
+		return cached.access, nil
+	}
+
+	access, err := auth.AccessFor(ctx, &user)
+	if err != nil {
+		return access, err
+	}
+	ctx.Set(userAccessContextKey, cachedUserAccess{userID: userID, access: access})
+	return access, nil
+}
+
+// AccessFor returns the roles and permissions of the user. They come from the
+// user or its data when one of them implements HasRoles, otherwise from the
+// RoleProvider.
+func (auth *Authentication) AccessFor(ctx context.Context, user Authenticable) (UserAccess, error) {
+	if hr, ok := rolesOf(user); ok {
+		return UserAccess{Roles: hr.GetRoles(), Permissions: hr.GetPermissions()}, nil
+	}
+	if auth.Roles == nil {
+		return UserAccess{}, nil
+	}
+
+	userID := AuthIdentifier(user).String()
+	roles, err := auth.Roles.RolesFor(ctx, userID)
+	if err != nil {
+		return UserAccess{}, err
+	}
+	permissions, err := auth.Roles.PermissionsFor(ctx, userID)
+	if err != nil {
+		return UserAccess{}, err
+	}
+	return UserAccess{Roles: roles, Permissions: permissions}, nil
+}
+
+// HasRole reports if the authenticated user has any of the roles
+func (auth *Authentication) HasRole(ctx Context, roles ...string) (bool, error) {
+	access, err := auth.Access(ctx)
+	if err != nil {
+		return false, err
+	}
+	return access.HasRole(roles...), nil
+}
+
+// HasPermission reports if the authenticated user has the permission
+func (auth *Authentication) HasPermission(ctx Context, permission string) (bool, error) {
+	access, err := auth.Access(ctx)
+	if err != nil {
+		return false, err
+	}
+	return access.HasPermission(permission), nil
+}
+
+func rolesOf(user Authenticable) (HasRoles, bool) {
+	if hr, ok := user.(HasRoles); ok {
+		return hr, true
+	}
+	hr, ok := user.GetAuthData().(HasRoles)
+	return hr, ok
+}
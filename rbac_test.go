@@ -0,0 +1,123 @@
+package dojo
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testRoleProvider struct {
+	calls int
+}
+
+func (p *testRoleProvider) RolesFor(ctx context.Context, userID string) ([]string, error) {
+	p.calls++
+	return []string{"editor"}, nil
+}
+
+func (p *testRoleProvider) PermissionsFor(ctx context.Context, userID string) ([]string, error) {
+	return []string{"posts.*"}, nil
+}
+
+type testRolesData struct {
+	roles []string
+}
+
+func (d testRolesData) GetRoles() []string {
+	return d.roles
+}
+
+func (d testRolesData) GetPermissions() []string {
+	return nil
+}
+
+type testUserWithRoles struct {
+	AuthUser
+}
+
+func (u *testUserWithRoles) GetRoles() []string {
+	return []string{"admin"}
+}
+
+func (u *testUserWithRoles) GetPermissions() []string {
+	return []string{"*"}
+}
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		pattern, permission string
+		want                bool
+	}{
+		{"posts.create", "posts.create", true},
+		{"posts.create", "posts.delete", false},
+		{"posts.*", "posts.create", true},
+		{"posts.*", "posts.comments.delete", true},
+		{"posts.*", "postsx.create", false},
+		{"posts.*", "posts", false},
+		{"*", "users.delete", true},
+		{"posts*", "posts.create", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPermission(tt.pattern, tt.permission); got != tt.want {
+			t.Errorf("MatchPermission(%q, %q) = %v, want %v", tt.pattern, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestUserAccess(t *testing.T) {
+	access := UserAccess{Roles: []string{"editor"}, Permissions: []string{"posts.*", "users.view"}}
+
+	if !access.HasRole("admin", "editor") || access.HasRole("admin") {
+		t.Error("expected any of the roles to match")
+	}
+	if !access.HasPermission("posts.publish") || !access.HasPermission("users.view") || access.HasPermission("users.delete") {
+		t.Error("expected the permissions to match with wildcards")
+	}
+}
+
+func TestAuthentication_AccessFor(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	provider := &testRoleProvider{}
+	app.Auth.Roles = provider
+	ctx := context.Background()
+
+	access, err := app.Auth.AccessFor(ctx, &testUserWithRoles{AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: testRolesData{roles: []string{"viewer"}}}})
+	if err != nil || !access.HasRole("admin") || access.HasRole("viewer") {
+		t.Errorf("expected the roles of the user before the ones of its data, got %+v %v", access, err)
+	}
+
+	access, err = app.Auth.AccessFor(ctx, &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4())), Data: testRolesData{roles: []string{"viewer"}}})
+	if err != nil || !access.HasRole("viewer") {
+		t.Errorf("expected the roles of the data, got %+v %v", access, err)
+	}
+
+	access, err = app.Auth.AccessFor(ctx, &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))})
+	if err != nil || !access.HasRole("editor") || !access.HasPermission("posts.create") || provider.calls != 1 {
+		t.Errorf("expected the roles of the provider, got %+v %v", access, err)
+	}
+}
+
+func TestAuthentication_AccessIsLoadedOncePerRequest(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+	})
+	provider := &testRoleProvider{}
+	app.Auth.Roles = provider
+
+	ctx := app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if access, err := app.Auth.Access(ctx); err != nil || len(access.Roles) != 0 || provider.calls != 0 {
+		t.Fatalf("expected guests to have no access, got %+v %v", access, err)
+	}
+
+	app.Auth.SetUser(ctx, &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))})
+	for i := 0; i < 2; i++ {
+		if ok, err := app.Auth.HasRole(ctx, "editor"); !ok || err != nil {
+			t.Fatalf("expected the editor role, got %v %v", ok, err)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected the roles to be loaded once, got %d calls", provider.calls)
+	}
+}
@@ -0,0 +1,19 @@
+package dojo
+
+import (
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis returns the redis client of the application, it is created from the
+// RedisConfig on first use.
+func (dojo *Dojo) Redis() *redis.Client {
+	dojo.redisOnce.Do(func() {
+		cfg := dojo.Configuration.Redis
+		dojo.redis = redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr(),
+			Password: cfg.Password,
+			DB:       cfg.Database,
+		})
+	})
+	return dojo.redis
+}
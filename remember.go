@@ -0,0 +1,243 @@
+package dojo
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRememberCookieName = "remember_token"
+	defaultRememberLifetime   = 30 * 24 * time.Hour
+	defaultRememberGrace      = 30 * time.Second
+)
+
+var (
+	// ErrRememberTokenNotFound is returned by a RememberTokenStore when no token exists for a selector.
+	ErrRememberTokenNotFound = errors.New("remember token not found")
+
+	// ErrRememberTokenTheft is returned when a rotated remember token is replayed.
+	// All remember tokens of the user are revoked when this happens.
+	ErrRememberTokenTheft = errors.New("remember token was replayed, possible theft")
+
+	// ErrRememberTokenConflict is returned by RememberTokenStore.Update when the
+	// hash of the token changed since it was read, another request rotated it.
+	ErrRememberTokenConflict = errors.New("remember token was rotated concurrently")
+
+	// ErrRememberNotConfigured is returned by Remember when the authentication
+	// has no user provider or no remember token store.
+	ErrRememberNotConfigured = errors.New("remember me needs a user provider and a token store")
+)
+
+// UserProvider loads the users of the application.
+type UserProvider interface {
+	RetrieveByID(ctx context.Context, id string) (Authenticable, error)
+}
+
+// RememberToken is a persistent login token. Only the selector is stored in
+// plain text, the validator is stored as sha256 hash. The hash of the
+// previous validator is kept for the grace period after a rotation.
+type RememberToken struct {
+	Selector     string
+	Hash         string
+	PreviousHash string
+	UserID       string
+	ExpiresAt    time.Time
+	RotatedAt    time.Time
+}
+
+// RememberTokenStore persists the remember me tokens.
+type RememberTokenStore interface {
+	Create(ctx context.Context, token RememberToken) error
+	Find(ctx context.Context, selector string) (RememberToken, error)
+	// Update replaces the token when its stored hash is still oldHash and
+	// returns ErrRememberTokenConflict otherwise.
+	Update(ctx context.Context, token RememberToken, oldHash string) error
+	Delete(ctx context.Context, selector string) error
+	DeleteForUser(ctx context.Context, userID string) error
+}
+
+func (auth *Authentication) rememberConfig() RememberConfig {
+	cfg := auth.dojo.Configuration.Auth.Remember
+	if cfg.CookieName == "" {
+		cfg.CookieName = defaultRememberCookieName
+	}
+	if cfg.Lifetime <= 0 {
+		cfg.Lifetime = defaultRememberLifetime
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = defaultRememberGrace
+	}
+	return cfg
+}
+
+// Remember issues a remember me token for the user and sets it as cookie.
+// Call it after Login when the user asked to be remembered.
+func (auth *Authentication) Remember(ctx Context, user Authenticable) error {
+	if auth.Users == nil || auth.RememberTokens == nil {
+		return ErrRememberNotConfigured
+	}
+
+	selector, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	validator, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	token := RememberToken{
+		Selector:  selector,
+		Hash:      hashRememberValidator(validator),
+		UserID:    AuthIdentifier(user).String(),
+		ExpiresAt: time.Now().Add(auth.rememberConfig().Lifetime),
+	}
+	if err := auth.RememberTokens.Create(ctx, token); err != nil {
+		return err
+	}
+
+	auth.setRememberCookie(ctx, selector, validator, token.ExpiresAt)
+	return nil
+}
+
+// LoginViaRemember re-establishes the session from the remember me cookie.
+// The token is rotated on every use, a replayed old token revokes all tokens
+// of the user and returns ErrRememberTokenTheft. The previous token is still
+// accepted for the grace period, concurrent requests of the browser send it
+// before they got the rotated one.
+func (auth *Authentication) LoginViaRemember(ctx Context) (bool, error) {
+	if auth.Users == nil || auth.RememberTokens == nil {
+		return false, nil
+	}
+
+	cfg := auth.rememberConfig()
+	value, err := ctx.Cookies().Get(cfg.CookieName)
+	if err != nil {
+		return false, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		auth.forgetRememberCookie(ctx)
+		return false, nil
+	}
+	selector, validator := parts[0], parts[1]
+
+	token, err := auth.RememberTokens.Find(ctx, selector)
+	if errors.Is(err, ErrRememberTokenNotFound) {
+		auth.forgetRememberCookie(ctx)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	hash := []byte(hashRememberValidator(validator))
+	previous := token.PreviousHash != "" && time.Since(token.RotatedAt) < cfg.Grace &&
+		subtle.ConstantTimeCompare([]byte(token.PreviousHash), hash) == 1
+	if !previous && subtle.ConstantTimeCompare([]byte(token.Hash), hash) != 1 {
+		auth.forgetRememberCookie(ctx)
+		auth.dojo.Logger.WithField("user_id", token.UserID).Warn("remember_token_theft")
+		if err := auth.RememberTokens.DeleteForUser(ctx, token.UserID); err != nil {
+			return false, err
+		}
+		return false, ErrRememberTokenTheft
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		auth.forgetRememberCookie(ctx)
+		return false, auth.RememberTokens.Delete(ctx, selector)
+	}
+
+	user, err := auth.Users.RetrieveByID(ctx, token.UserID)
+	if err != nil {
+		return false, err
+	}
+	if err := auth.Login(ctx, user); err != nil {
+		return false, err
+	}
+	if previous {
+		// The request that rotated the token already sent the new cookie
+		return true, nil
+	}
+
+	// Rotate the validator, the selector stays the same so a replay of the old one is detected
+	validator, err = randomToken(32)
+	if err != nil {
+		return false, err
+	}
+	oldHash := token.Hash
+	token.PreviousHash = oldHash
+	token.Hash = hashRememberValidator(validator)
+	token.RotatedAt = time.Now()
+	token.ExpiresAt = token.RotatedAt.Add(cfg.Lifetime)
+	err = auth.RememberTokens.Update(ctx, token, oldHash)
+	if errors.Is(err, ErrRememberTokenConflict) {
+		// A concurrent request rotated the token first, like in the grace
+		// period its cookie is the one the browser keeps
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	auth.setRememberCookie(ctx, selector, validator, token.ExpiresAt)
+
+	return true, nil
+}
+
+func (auth *Authentication) forgetRemember(ctx Context) error {
+	if auth.RememberTokens == nil {
+		return nil
+	}
+	value, err := ctx.Cookies().Get(auth.rememberConfig().CookieName)
+	if err != nil {
+		return nil
+	}
+	auth.forgetRememberCookie(ctx)
+	selector := strings.SplitN(value, ":", 2)[0]
+	return auth.RememberTokens.Delete(ctx, selector)
+}
+
+func (auth *Authentication) setRememberCookie(ctx Context, selector, validator string, expires time.Time) {
+	http.SetCookie(ctx.Response(), &http.Cookie{
+		Name:     auth.rememberConfig().CookieName,
+		Value:    fmt.Sprintf("%s:%s", selector, validator),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   auth.dojo.SessionStore.Options.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (auth *Authentication) forgetRememberCookie(ctx Context) {
+	http.SetCookie(ctx.Response(), &http.Cookie{
+		Name:     auth.rememberConfig().CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   auth.dojo.SessionStore.Options.Secure,
+	})
+}
+
+func hashRememberValidator(validator string) string {
+	sum := sha256.Sum256([]byte(validator))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n uint32) (string, error) {
+	b, err := generateRandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
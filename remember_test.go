@@ -0,0 +1,202 @@
+package dojo
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testRememberTokens struct {
+	tokens  map[string]RememberToken
+	revoked []string
+	// beforeUpdate runs between the Find and the Update of a rotation
+	beforeUpdate func()
+}
+
+func (s *testRememberTokens) Create(ctx context.Context, token RememberToken) error {
+	s.tokens[token.Selector] = token
+	return nil
+}
+
+func (s *testRememberTokens) Find(ctx context.Context, selector string) (RememberToken, error) {
+	token, ok := s.tokens[selector]
+	if !ok {
+		return token, ErrRememberTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *testRememberTokens) Update(ctx context.Context, token RememberToken, oldHash string) error {
+	if s.beforeUpdate != nil {
+		s.beforeUpdate()
+	}
+	if s.tokens[token.Selector].Hash != oldHash {
+		return ErrRememberTokenConflict
+	}
+	s.tokens[token.Selector] = token
+	return nil
+}
+
+func (s *testRememberTokens) Delete(ctx context.Context, selector string) error {
+	delete(s.tokens, selector)
+	return nil
+}
+
+func (s *testRememberTokens) DeleteForUser(ctx context.Context, userID string) error {
+	s.revoked = append(s.revoked, userID)
+	for selector, token := range s.tokens {
+		if token.UserID == userID {
+			delete(s.tokens, selector)
+		}
+	}
+	return nil
+}
+
+func newRememberApp() (*Dojo, *testRememberTokens, *AuthUser) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+		Auth:    AuthenticationConfig{Remember: RememberConfig{Grace: time.Minute}},
+	})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	user := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+	store := &testRememberTokens{tokens: map[string]RememberToken{}}
+	app.Auth.Users = &testBrokerUsers{user: &testBrokerUser{AuthUser: *user}}
+	app.Auth.RememberTokens = store
+
+	app.Route.Get("/login", func(ctx Context) error {
+		if err := ctx.Dojo().Auth.Login(ctx, user); err != nil {
+			return err
+		}
+		return ctx.Dojo().Auth.Remember(ctx, user)
+	})
+	app.Route.Get("/remembered", func(ctx Context) error {
+		ok, err := ctx.Dojo().Auth.LoginViaRemember(ctx)
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, ok)
+	})
+	app.Route.Get("/logout", func(ctx Context) error {
+		return ctx.Dojo().Auth.Logout(ctx)
+	})
+	return app, store, user
+}
+
+// rememberCookie sends the remember me cookie and returns the response and the rotated cookie
+func rememberCookie(app *Dojo, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == defaultRememberCookieName {
+			return rec, c
+		}
+	}
+	return rec, nil
+}
+
+func TestAuthentication_RememberRotates(t *testing.T) {
+	app, store, user := newRememberApp()
+
+	_, issued := rememberCookie(app, "/login", nil)
+	if issued == nil || len(store.tokens) != 1 {
+		t.Fatalf("expected a remember cookie and a stored token, got %v %d", issued, len(store.tokens))
+	}
+	for _, token := range store.tokens {
+		if token.UserID != user.GetAuthIdentifier().String() || token.Hash == issued.Value {
+			t.Errorf("expected the hash of the validator for the user, got %+v", token)
+		}
+	}
+
+	rec, rotated := rememberCookie(app, "/remembered", issued)
+	if rec.Body.String() != `{"data":true}` || rotated == nil || rotated.Value == issued.Value {
+		t.Fatalf("expected a login with a rotated cookie, got %s %v", rec.Body.String(), rotated)
+	}
+
+	// A concurrent request still carries the previous cookie
+	rec, again := rememberCookie(app, "/remembered", issued)
+	if rec.Body.String() != `{"data":true}` || again != nil {
+		t.Errorf("expected the previous token to be accepted without a rotation, got %s %v", rec.Body.String(), again)
+	}
+	if rec, _ := rememberCookie(app, "/remembered", rotated); rec.Body.String() != `{"data":true}` {
+		t.Errorf("expected the rotated token to stay valid, got %s", rec.Body.String())
+	}
+}
+
+func TestAuthentication_RememberConcurrentRotation(t *testing.T) {
+	app, store, _ := newRememberApp()
+
+	_, issued := rememberCookie(app, "/login", nil)
+	store.beforeUpdate = func() {
+		// Another request of the browser rotates the token first
+		for selector, token := range store.tokens {
+			token.PreviousHash = token.Hash
+			token.Hash = "rotated by the other request"
+			token.RotatedAt = time.Now()
+			store.tokens[selector] = token
+		}
+		store.beforeUpdate = nil
+	}
+
+	rec, rotated := rememberCookie(app, "/remembered", issued)
+	if rec.Body.String() != `{"data":true}` || rotated != nil {
+		t.Fatalf("expected the losing rotation to log in without a new cookie, got %s %v", rec.Body.String(), rotated)
+	}
+	if len(store.revoked) != 0 || len(store.tokens) != 1 {
+		t.Errorf("expected no revocation, got %v %d", store.revoked, len(store.tokens))
+	}
+	for _, token := range store.tokens {
+		if token.Hash != "rotated by the other request" {
+			t.Errorf("expected the winning rotation to be kept, got %+v", token)
+		}
+	}
+}
+
+func TestAuthentication_RememberTheft(t *testing.T) {
+	app, store, user := newRememberApp()
+
+	_, issued := rememberCookie(app, "/login", nil)
+	_, rotated := rememberCookie(app, "/remembered", issued)
+	for selector, token := range store.tokens {
+		token.RotatedAt = time.Now().Add(-2 * time.Minute)
+		store.tokens[selector] = token
+	}
+
+	rec, cleared := rememberCookie(app, "/remembered", issued)
+	if rec.Code != http.StatusInternalServerError || cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("expected the replay to fail and clear the cookie, got %d %v", rec.Code, cleared)
+	}
+	if len(store.revoked) != 1 || store.revoked[0] != user.GetAuthIdentifier().String() || len(store.tokens) != 0 {
+		t.Fatalf("expected all tokens of the user to be revoked, got %v %d", store.revoked, len(store.tokens))
+	}
+	if rec, _ := rememberCookie(app, "/remembered", rotated); rec.Body.String() != `{"data":false}` {
+		t.Errorf("expected the rotated token to be revoked too, got %s", rec.Body.String())
+	}
+}
+
+func TestAuthentication_ForgetRemember(t *testing.T) {
+	app, store, _ := newRememberApp()
+
+	_, issued := rememberCookie(app, "/login", nil)
+	_, cleared := rememberCookie(app, "/logout", issued)
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("expected the logout to clear the cookie, got %v", cleared)
+	}
+	if len(store.tokens) != 0 {
+		t.Errorf("expected the token to be deleted, got %d", len(store.tokens))
+	}
+}
+
+func TestAuthentication_RememberNeedsAStore(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	ctx := app.NewContext(RouteConfig{}, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := app.Auth.Remember(ctx, &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}); err != ErrRememberNotConfigured {
+		t.Errorf("expected ErrRememberNotConfigured, got %v", err)
+	}
+}
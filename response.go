@@ -0,0 +1,84 @@
+package dojo
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Response wraps the http.ResponseWriter of a request and records the
+// status, the number of bytes written and if the headers were sent.
+type Response struct {
+	Writer    http.ResponseWriter
+	Status    int
+	Size      int64
+	Committed bool
+	Start     time.Time
+}
+
+var errHijackNotSupported = errors.New("the response writer does not support hijacking")
+
+// NewResponse wraps the writer, the status is 200 until WriteHeader is called
+func NewResponse(w http.ResponseWriter) *Response {
+	return &Response{Writer: w, Status: http.StatusOK, Start: time.Now()}
+}
+
+func (r *Response) Header() http.Header {
+	return r.Writer.Header()
+}
+
+// WriteHeader sends the status code, every call after the first one is ignored
+func (r *Response) WriteHeader(code int) {
+	if r.Committed {
+		return
+	}
+	r.Status = code
+	r.Committed = true
+	r.Writer.WriteHeader(code)
+}
+
+func (r *Response) Write(b []byte) (int, error) {
+	if !r.Committed {
+		r.WriteHeader(r.Status)
+	}
+	n, err := r.Writer.Write(b)
+	r.Size += int64(n)
+	return n, err
+}
+
+// Duration is the time since the response was created
+func (r *Response) Duration() time.Duration {
+	return time.Since(r.Start)
+}
+
+func (r *Response) Flush() {
+	if !r.Committed {
+		r.WriteHeader(r.Status)
+	}
+	if f, ok := r.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.Writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	r.Committed = true
+	return h.Hijack()
+}
+
+func (r *Response) Push(target string, opts *http.PushOptions) error {
+	if p, ok := r.Writer.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the original writer for http.ResponseController
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.Writer
+}
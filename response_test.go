@@ -0,0 +1,33 @@
+package dojo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	res := NewResponse(rec)
+
+	var w http.ResponseWriter = res
+	if _, ok := w.(http.Flusher); !ok {
+		t.Fatal("the response must keep the flusher")
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+	res.WriteHeader(http.StatusInternalServerError)
+	if _, err := res.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Committed || res.Status != http.StatusAccepted || res.Size != 5 {
+		t.Errorf("unexpected response state %+v", res)
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("the second status must be ignored, got %d", rec.Code)
+	}
+	if _, _, err := res.Hijack(); err == nil {
+		t.Error("expected an error for writers that can't be hijacked")
+	}
+}
@@ -1,33 +1,86 @@
 package dojo
 
 import (
+	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strings"
 )
 
 type Router struct {
 	middlewares []string
 	router      *mux.Router
 	dojo        *Dojo
+	preflights  map[string]*preflightRoute
+	// resolved are the middlewares of the specs, they are resolved once per
+	// router so the routes share them, "throttle:60,1" is one limit for all.
+	resolved map[string]MiddlewareFunc
+}
+
+// preflightRoute answers the OPTIONS requests of a path that has no
+// explicit Options route, so CORS preflights reach the middlewares of the router.
+type preflightRoute struct {
+	route   *mux.Route
+	methods []string
+	// allow is the Allow header, it is built when a route is added so the
+	// requests only read it
+	allow string
+	// configs are the preflights of the methods, each one runs the middlewares
+	// up to cors of the router the method was registered on
+	configs  map[string]RouteConfig
+	explicit bool
 }
 
 func NewRouter(dojo *Dojo) *Router {
 	r := mux.NewRouter()
-	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets/dist"))))
-	return &Router{router: r, dojo: dojo}
+	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", precompressedFileServer(http.Dir("./assets/dist"))))
+	return &Router{router: r, dojo: dojo, resolved: make(map[string]MiddlewareFunc)}
 }
 
 func (r *Router) GetMux() *mux.Router {
 	return r.router
 }
 
-// Use a registered middleware on that router
+// Use a registered middleware on that router, the arguments of middleware
+// factories follow the name like "throttle:60,1".
 func (r *Router) Use(name string) {
 	r.middlewares = append(r.middlewares, name)
 }
 
+// WithoutMiddleware returns a copy of the router without the named
+// middlewares, use it to register single routes without them:
+//
+//	app.Route.WithoutMiddleware("csrf").Post("/webhooks/stripe", handler)
+func (r *Router) WithoutMiddleware(names ...string) *Router {
+	// The copy shares the preflights, a path has one OPTIONS route no matter
+	// which of the routers registers its methods
+	if r.preflights == nil {
+		r.preflights = make(map[string]*preflightRoute)
+	}
+	clone := *r
+	clone.middlewares = nil
+	for _, spec := range r.middlewares {
+		if !containsString(names, middlewareName(spec)) {
+			clone.middlewares = append(clone.middlewares, spec)
+		}
+	}
+	return &clone
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Router) UseStack(name string) {
-	stack := r.dojo.MiddlewareRegistry.stacks[name]
+	stack, ok := r.dojo.MiddlewareRegistry.stacks[name]
+	if !ok {
+		panic(fmt.Sprintf("dojo: middleware stack %s is not registered", name))
+	}
 	r.middlewares = append(r.middlewares, stack...)
 }
 
@@ -86,29 +139,40 @@ func (r *Router) Connect(path string, handler Handler, middlewares ...Middleware
 func (r *Router) Host(tpl string, cb func(router *Router)) {
 	sr := r.router.Host(tpl).Subrouter()
 	cb(&Router{
-		router: sr,
-		dojo:   r.dojo,
+		router:   sr,
+		dojo:     r.dojo,
+		resolved: make(map[string]MiddlewareFunc),
 	})
 }
 
 func (r *Router) RouteGroup(prefix string, cb func(router *Router)) {
 	sr := r.router.PathPrefix(prefix).Subrouter()
 	cb(&Router{
-		router: sr,
-		dojo:   r.dojo,
+		router:   sr,
+		dojo:     r.dojo,
+		resolved: make(map[string]MiddlewareFunc),
 	})
 }
 
 func (r *Router) getRouteConfig(method string, url string, h Handler) RouteConfig {
+	return r.routeConfig(method, url, h, r.dojo.MiddlewareRegistry.sortByPriority(r.middlewares))
+}
+
+func (r *Router) routeConfig(method string, url string, h Handler, specs []string) RouteConfig {
 	mws := MiddlewareStack{}
 	app := r.dojo
 
-	for _, mName := range r.middlewares {
-		mw, err := app.MiddlewareRegistry.findMiddleware(mName)
-		if err != nil {
-			continue
+	for _, mName := range specs {
+		mw, ok := r.resolved[mName]
+		if !ok {
+			var err error
+			mw, err = app.MiddlewareRegistry.Resolve(mName)
+			if err != nil {
+				panic(fmt.Sprintf("dojo: route %s %s: %v", method, url, err))
+			}
+			r.resolved[mName] = mw
 		}
-		mws.Use(mw.Handler)
+		mws.Use(mw)
 	}
 
 	return RouteConfig{
@@ -123,15 +187,65 @@ func (r *Router) getRouteConfig(method string, url string, h Handler) RouteConfi
 }
 
 func (r *Router) addNamedRoute(method string, url string, name string, h Handler, middlewares ...MiddlewareFunc) {
-	config := r.getRouteConfig(method, url, h)
-	config.Middlewares.Use(middlewares...)
-	config.MuxRoute = r.router.Handle(url, config).Methods(method).Name(name)
+	r.handle(method, url, h, middlewares...).Name(name)
 }
 
 func (r *Router) addRoute(method string, url string, h Handler, middlewares ...MiddlewareFunc) {
+	r.handle(method, url, h, middlewares...)
+}
+
+func (r *Router) handle(method string, url string, h Handler, middlewares ...MiddlewareFunc) *mux.Route {
 	config := r.getRouteConfig(method, url, h)
 	config.Middlewares.Use(middlewares...)
+
+	if r.preflights == nil {
+		r.preflights = make(map[string]*preflightRoute)
+	}
+	p, exists := r.preflights[url]
+
+	if method == http.MethodOptions {
+		if exists && !p.explicit {
+			// Replace the automatic preflight handler with the explicit one
+			p.explicit = true
+			config.MuxRoute = p.route
+			p.route.Handler(config)
+			return p.route
+		}
+		config.MuxRoute = r.router.Handle(url, config).Methods(method)
+		r.preflights[url] = &preflightRoute{route: config.MuxRoute, explicit: true}
+		return config.MuxRoute
+	}
+
 	config.MuxRoute = r.router.Handle(url, config).Methods(method)
+	if !exists {
+		p = &preflightRoute{configs: make(map[string]RouteConfig)}
+		r.preflights[url] = p
+		p.route = r.router.Handle(url, p).Methods(http.MethodOptions)
+	}
+	if _, ok := p.configs[method]; !ok && !p.explicit {
+		// The preflight only runs the middlewares of the router up to cors,
+		// the ones of the route belong to its method
+		specs := r.dojo.MiddlewareRegistry.preflightMiddlewares(r.middlewares)
+		p.configs[method] = r.routeConfig(http.MethodOptions, url, p.handler, specs)
+	}
+	p.methods = append(p.methods, method)
+	p.allow = strings.Join(p.methods, ", ") + ", " + http.MethodOptions
+	return config.MuxRoute
+}
+
+// ServeHTTP runs the preflight of the requested method, the one of the first
+// method for plain OPTIONS requests
+func (p *preflightRoute) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	config, ok := p.configs[req.Header.Get(HeaderAccessControlRequestMethod)]
+	if !ok {
+		config = p.configs[p.methods[0]]
+	}
+	config.ServeHTTP(res, req)
+}
+
+func (p *preflightRoute) handler(ctx Context) error {
+	ctx.Response().Header().Set(HeaderAllow, p.allow)
+	return ctx.NoContent(http.StatusNoContent)
 }
 
 type RouteConfig struct {
@@ -149,7 +263,15 @@ type RouteConfig struct {
 
 func (r RouteConfig) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	app := r.Dojo
-	c := app.NewContext(r, res, req)
+	var c Context
+	if global, ok := req.Context().Value(requestContextKey).(*DefaultContext); ok {
+		// The global middlewares already created the context of the request
+		global.forRoute(r, req)
+		c = global
+	} else {
+		c = app.NewContext(r, res, req)
+		app.Auth.logImpersonation(c)
+	}
 	err := r.Middlewares.handler(r)(c)
 	if err != nil {
 		app.HTTPErrorHandler(err, c)
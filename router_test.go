@@ -4,6 +4,8 @@ import (
 	"github.com/steinfletcher/apitest"
 	"github.com/steinfletcher/apitest-jsonpath"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -60,3 +62,78 @@ func TestRouter_RegisterMiddleware(t *testing.T) {
 		Status(http.StatusOK).
 		End()
 }
+
+func TestRouter_MiddlewareFactory(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	r := NewRouter(app)
+
+	app.MiddlewareRegistry.RegisterFactory("tag", func(args ...string) (MiddlewareFunc, error) {
+		return func(next Handler) Handler {
+			return func(ctx Context) error {
+				return ctx.JSON(200, args)
+			}
+		}, nil
+	})
+
+	r.Use("tag:admin, editor")
+	r.Get("/test", func(ctx Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	})
+
+	apitest.New().
+		Handler(r.GetMux()).
+		Get("/test").
+		Expect(t).
+		Body(`{"data":["admin","editor"]}`).
+		Status(http.StatusOK).
+		End()
+}
+
+func TestRouter_UnknownMiddlewarePanics(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	app.MiddlewareRegistry.Register("auth", func(next Handler) Handler { return next })
+
+	for _, name := range []string{"atuh", "auth:admin"} {
+		r := NewRouter(app)
+		r.Use(name)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %s to panic at route registration", name)
+				}
+			}()
+			r.Get("/test", func(ctx Context) error { return nil })
+		}()
+	}
+}
+
+func TestRouter_PriorityAndWithoutMiddleware(t *testing.T) {
+	app := New(DefaultConfiguration{})
+	var order []string
+	for _, name := range []string{"auth", "csrf", "custom"} {
+		name := name
+		app.MiddlewareRegistry.Register(name, func(next Handler) Handler {
+			return func(ctx Context) error {
+				order = append(order, name)
+				return next(ctx)
+			}
+		})
+	}
+	app.Route.Use("custom")
+	app.Route.Use("auth")
+	app.Route.Use("csrf")
+
+	handler := func(ctx Context) error { return ctx.NoContent(http.StatusNoContent) }
+	app.Route.Post("/form", handler)
+	app.Route.WithoutMiddleware("csrf").Post("/webhook", handler)
+
+	expect := map[string]string{"/form": "custom,csrf,auth", "/webhook": "custom,auth"}
+	for path, chain := range expect {
+		order = nil
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if got := strings.Join(order, ","); got != chain {
+			t.Errorf("%s: expected the middlewares %s, got %s", path, chain, got)
+		}
+	}
+}
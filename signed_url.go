@@ -0,0 +1,66 @@
+package dojo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureParam = "signature"
+	expiresParam   = "expires"
+)
+
+// ErrNoSigningSecret is returned when a url is signed without a session secret
+var ErrNoSigningSecret = errors.New("signed urls need a session secret")
+
+// SignedURL returns the absolute url of the path with an expiry and a
+// signature made with the session secret, it can be validated with HasValidSignature.
+func (dojo *Dojo) SignedURL(path string, params url.Values, expires time.Time) (string, error) {
+	if dojo.Configuration.Session.Secret == "" {
+		return "", ErrNoSigningSecret
+	}
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set(expiresParam, strconv.FormatInt(expires.Unix(), 10))
+	q.Set(signatureParam, dojo.sign(path, q))
+	return fmt.Sprintf("%s%s?%s", dojo.Configuration.App.Domain, path, q.Encode()), nil
+}
+
+// HasValidSignature reports if the url of the request was signed by SignedURL and is not expired
+func (dojo *Dojo) HasValidSignature(r *http.Request) bool {
+	if dojo.Configuration.Session.Secret == "" {
+		return false
+	}
+	q := r.URL.Query()
+	signature := q.Get(signatureParam)
+	if signature == "" {
+		return false
+	}
+	expires, err := strconv.ParseInt(q.Get(expiresParam), 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return false
+	}
+	q.Del(signatureParam)
+	return hmac.Equal([]byte(signature), []byte(dojo.sign(r.URL.Path, q)))
+}
+
+func (dojo *Dojo) sign(path string, q url.Values) string {
+	signed := url.Values{}
+	for k, v := range q {
+		if k != signatureParam {
+			signed[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(dojo.Configuration.Session.Secret))
+	mac.Write([]byte(path + "?" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
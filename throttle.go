@@ -0,0 +1,355 @@
+package dojo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultThrottleMaxAttempts      = 5
+	defaultThrottleMaxAttemptsPerIP = 20
+	defaultThrottleDecayWindow      = time.Minute
+	defaultThrottleLockoutTime      = time.Minute
+)
+
+// ThrottleStore keeps track of the failed login attempts and lockouts.
+type ThrottleStore interface {
+	// Hit increments the attempts for the key and returns the new count.
+	// The counter is reset once the decay window is over.
+	Hit(ctx context.Context, key string, decay time.Duration) (int, error)
+	// Lock locks the key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil returns the time until the key is locked, a zero time if it is not locked.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Clear removes the attempts and the lockout of the key.
+	Clear(ctx context.Context, key string) error
+}
+
+// Throttle scopes of a LockoutEvent
+const (
+	UsernameThrottleScope = "username"
+	IPThrottleScope       = "ip"
+)
+
+// LockoutEvent is passed to the OnLockout hook when a login gets locked.
+// Scope tells if the username or the ip of the request got locked.
+type LockoutEvent struct {
+	Username string
+	IP       string
+	Scope    string
+	Attempts int
+	Until    time.Time
+}
+
+// LoginThrottle limits the failed login attempts per username and per ip.
+// The username counter stops the attacks on one account from many ips, the
+// ip counter stops one ip that tries many usernames.
+type LoginThrottle struct {
+	Store            ThrottleStore
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	DecayWindow      time.Duration
+	LockoutTime      time.Duration
+	// OnLockout is called every time a login gets locked, use it to audit the lockouts.
+	OnLockout func(ctx Context, event LockoutEvent)
+}
+
+func NewLoginThrottle(cfg LoginThrottleConfig, store ThrottleStore) *LoginThrottle {
+	t := &LoginThrottle{
+		Store:            store,
+		MaxAttempts:      cfg.MaxAttempts,
+		MaxAttemptsPerIP: cfg.MaxAttemptsPerIP,
+		DecayWindow:      cfg.DecayWindow,
+		LockoutTime:      cfg.LockoutTime,
+	}
+	if t.MaxAttempts <= 0 {
+		t.MaxAttempts = defaultThrottleMaxAttempts
+	}
+	if t.MaxAttemptsPerIP <= 0 {
+		t.MaxAttemptsPerIP = defaultThrottleMaxAttemptsPerIP
+	}
+	if t.DecayWindow <= 0 {
+		t.DecayWindow = defaultThrottleDecayWindow
+	}
+	if t.LockoutTime <= 0 {
+		t.LockoutTime = defaultThrottleLockoutTime
+	}
+	return t
+}
+
+var (
+	throttleStoreDriversMu sync.RWMutex
+	throttleStoreDrivers   = make(map[ThrottleStoreDriver]func(dojo *Dojo) ThrottleStore)
+)
+
+// RegisterThrottleStoreDriver makes a store driver available to the throttle
+// configuration, the db package registers the postgres driver when it is imported.
+func RegisterThrottleStoreDriver(driver ThrottleStoreDriver, factory func(dojo *Dojo) ThrottleStore) {
+	throttleStoreDriversMu.Lock()
+	defer throttleStoreDriversMu.Unlock()
+	throttleStoreDrivers[driver] = factory
+}
+
+// Validate reports an unknown store driver, the postgres driver is only
+// known when the db package is imported.
+func (cfg LoginThrottleConfig) Validate() error {
+	switch cfg.Store {
+	case "", MemoryThrottleStoreDriver, RedisThrottleStoreDriver:
+		return nil
+	}
+	throttleStoreDriversMu.RLock()
+	defer throttleStoreDriversMu.RUnlock()
+	if _, ok := throttleStoreDrivers[cfg.Store]; !ok {
+		return fmt.Errorf("unknown throttle store driver %q, the postgres driver needs the db package to be imported", cfg.Store)
+	}
+	return nil
+}
+
+// newThrottleStore creates the store of the configuration, New panics for
+// an unknown driver before the application serves requests.
+func newThrottleStore(dojo *Dojo) ThrottleStore {
+	cfg := dojo.Configuration.Auth.Throttle
+	if err := cfg.Validate(); err != nil {
+		panic("dojo: " + err.Error())
+	}
+	switch cfg.Store {
+	case "", MemoryThrottleStoreDriver:
+		return NewMemoryThrottleStore()
+	case RedisThrottleStoreDriver:
+		return NewRedisThrottleStore(dojo.Redis())
+	}
+
+	throttleStoreDriversMu.RLock()
+	factory := throttleStoreDrivers[cfg.Store]
+	throttleStoreDriversMu.RUnlock()
+	return factory(dojo)
+}
+
+func usernameThrottleKey(username string) string {
+	return "username:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipThrottleKey(ctx Context) string {
+	return "ip:" + ctx.RealIP()
+}
+
+// Check returns ErrTooManyRequests and sets the Retry-After header when the
+// username or the ip of the request is locked.
+func (t *LoginThrottle) Check(ctx Context, username string) error {
+	var until time.Time
+	for _, key := range []string{usernameThrottleKey(username), ipThrottleKey(ctx)} {
+		locked, err := t.Store.LockedUntil(ctx, key)
+		if err != nil {
+			return err
+		}
+		if locked.After(until) {
+			until = locked
+		}
+	}
+	if until.After(time.Now()) {
+		return tooManyAttempts(ctx, until)
+	}
+	return nil
+}
+
+// Failed records a failed login attempt for the username and the ip. Once
+// either of them reaches its max attempts it gets locked and
+// ErrTooManyRequests is returned.
+func (t *LoginThrottle) Failed(ctx Context, username string) error {
+	limits := []struct {
+		scope string
+		key   string
+		max   int
+	}{
+		{UsernameThrottleScope, usernameThrottleKey(username), t.MaxAttempts},
+		{IPThrottleScope, ipThrottleKey(ctx), t.MaxAttemptsPerIP},
+	}
+
+	var until time.Time
+	for _, limit := range limits {
+		attempts, err := t.Store.Hit(ctx, limit.key, t.DecayWindow)
+		if err != nil {
+			return err
+		}
+		if attempts < limit.max {
+			continue
+		}
+
+		until = time.Now().Add(t.LockoutTime)
+		if err := t.Store.Lock(ctx, limit.key, until); err != nil {
+			return err
+		}
+		t.lockedOut(ctx, LockoutEvent{
+			Username: username,
+			IP:       ctx.RealIP(),
+			Scope:    limit.scope,
+			Attempts: attempts,
+			Until:    until,
+		})
+	}
+
+	if until.IsZero() {
+		return nil
+	}
+	return tooManyAttempts(ctx, until)
+}
+
+func (t *LoginThrottle) lockedOut(ctx Context, event LockoutEvent) {
+	ctx.Dojo().Logger.WithFields(map[string]interface{}{
+		"username": event.Username,
+		"ip":       event.IP,
+		"scope":    event.Scope,
+		"attempts": event.Attempts,
+		"until":    event.Until,
+	}).Warn("login_lockout")
+	if t.OnLockout != nil {
+		t.OnLockout(ctx, event)
+	}
+}
+
+// Succeeded clears the attempts of the username after a successful login.
+// The ip counter keeps running, an attacker can't reset it with an account
+// of their own.
+func (t *LoginThrottle) Succeeded(ctx Context, username string) error {
+	return t.Store.Clear(ctx, usernameThrottleKey(username))
+}
+
+func tooManyAttempts(ctx Context, until time.Time) error {
+	seconds := int(time.Until(until).Seconds()) + 1
+	ctx.Response().Header().Set(HeaderRetryAfter, strconv.Itoa(seconds))
+	return ErrTooManyRequests
+}
+
+type memoryThrottleEntry struct {
+	attempts    int
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// MemoryThrottleStore keeps the attempts in the memory of the process.
+type MemoryThrottleStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryThrottleEntry
+	lastSweep time.Time
+}
+
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{entries: make(map[string]*memoryThrottleEntry)}
+}
+
+func (s *MemoryThrottleStore) Hit(_ context.Context, key string, decay time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryThrottleEntry{}
+		s.entries[key] = e
+	}
+	if now.After(e.expiresAt) {
+		e.attempts = 0
+		e.expiresAt = now.Add(decay)
+	}
+	e.attempts++
+	return e.attempts, nil
+}
+
+func (s *MemoryThrottleStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryThrottleEntry{}
+		s.entries[key] = e
+	}
+	e.lockedUntil = until
+	return nil
+}
+
+func (s *MemoryThrottleStore) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return e.lockedUntil, nil
+}
+
+func (s *MemoryThrottleStore) Clear(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep removes the entries whose attempts and lockout are over once a minute
+func (s *MemoryThrottleStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if now.After(e.expiresAt) && now.After(e.lockedUntil) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// RedisThrottleStore keeps the attempts in redis so they are shared between instances.
+type RedisThrottleStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func NewRedisThrottleStore(client *redis.Client) *RedisThrottleStore {
+	return &RedisThrottleStore{Client: client, Prefix: "dojo:throttle:"}
+}
+
+// throttleHitScript increments the attempts and starts the decay window with
+// the first one in one step, so no counter is left without expiry.
+var throttleHitScript = redis.NewScript(`
+local attempts = redis.call('INCR', KEYS[1])
+if attempts == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return attempts
+`)
+
+func (s *RedisThrottleStore) Hit(ctx context.Context, key string, decay time.Duration) (int, error) {
+	attempts, err := throttleHitScript.Run(ctx, s.Client, []string{s.Prefix + key}, decay.Milliseconds()).Int()
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+func (s *RedisThrottleStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.Client.Set(ctx, s.Prefix+key+":lockout", until.Unix(), time.Until(until)).Err()
+}
+
+func (s *RedisThrottleStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	ts, err := s.Client.Get(ctx, s.Prefix+key+":lockout").Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
+
+func (s *RedisThrottleStore) Clear(ctx context.Context, key string) error {
+	return s.Client.Del(ctx, s.Prefix+key, s.Prefix+key+":lockout").Err()
+}
@@ -0,0 +1,182 @@
+package dojo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle_Failed(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Auth: AuthenticationConfig{
+			Throttle: LoginThrottleConfig{MaxAttempts: 3, DecayWindow: time.Minute, LockoutTime: time.Minute},
+		},
+	})
+
+	var events []LockoutEvent
+	app.Auth.Throttle.OnLockout = func(ctx Context, event LockoutEvent) {
+		events = append(events, event)
+	}
+
+	newCtx := func() (Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		return app.NewContext(RouteConfig{}, rec, req), rec
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx, _ := newCtx()
+		if err := app.Auth.Throttle.Failed(ctx, "john@example.com"); err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i+1, err)
+		}
+	}
+
+	ctx, rec := newCtx()
+	if err := app.Auth.Throttle.Failed(ctx, "John@example.com"); err != ErrTooManyRequests {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+	if rec.Header().Get(HeaderRetryAfter) == "" {
+		t.Error("expected a Retry-After header")
+	}
+	if len(events) != 1 || events[0].Attempts != 3 {
+		t.Errorf("expected one lockout event after 3 attempts, got %v", events)
+	}
+
+	ctx, _ = newCtx()
+	if err := app.Auth.Throttle.Check(ctx, "john@example.com"); err != ErrTooManyRequests {
+		t.Errorf("expected the login to be locked, got %v", err)
+	}
+
+	if err := app.Auth.Throttle.Succeeded(ctx, "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Auth.Throttle.Check(ctx, "john@example.com"); err != nil {
+		t.Errorf("expected the lockout to be cleared, got %v", err)
+	}
+}
+
+func TestMemoryThrottleStore_Sweep(t *testing.T) {
+	store := NewMemoryThrottleStore()
+	ctx := context.Background()
+	if _, err := store.Hit(ctx, "expired", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Lock(ctx, "locked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	store.lastSweep = time.Now().Add(-2 * time.Minute)
+	if _, err := store.Hit(ctx, "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.entries["expired"]; ok {
+		t.Error("expected the expired attempts to be removed")
+	}
+	if _, ok := store.entries["locked"]; !ok {
+		t.Error("expected the locked entry to be kept until the lockout is over")
+	}
+}
+
+type testThrottleStore struct {
+	*MemoryThrottleStore
+}
+
+func TestNewThrottleStore_RegisteredDriver(t *testing.T) {
+	store := &testThrottleStore{MemoryThrottleStore: NewMemoryThrottleStore()}
+	RegisterThrottleStoreDriver("test", func(*Dojo) ThrottleStore {
+		return store
+	})
+
+	app := New(DefaultConfiguration{Auth: AuthenticationConfig{Throttle: LoginThrottleConfig{Store: "test"}}})
+	if app.Auth.Throttle.Store != store {
+		t.Errorf("expected the store of the registered driver, got %T", app.Auth.Throttle.Store)
+	}
+}
+
+func TestLoginThrottle_CountsUsernamesAndIPsSeparately(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Auth: AuthenticationConfig{
+			Throttle: LoginThrottleConfig{MaxAttempts: 3, MaxAttemptsPerIP: 5},
+		},
+	})
+	var scopes []string
+	app.Auth.Throttle.OnLockout = func(ctx Context, event LockoutEvent) {
+		scopes = append(scopes, event.Scope)
+	}
+	newCtx := func(ip string) Context {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		return app.NewContext(RouteConfig{}, httptest.NewRecorder(), req)
+	}
+
+	// One ip that sprays usernames
+	for i := 0; i < 4; i++ {
+		if err := app.Auth.Throttle.Failed(newCtx("10.0.0.1"), fmt.Sprintf("user%d@example.com", i)); err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i+1, err)
+		}
+	}
+	if err := app.Auth.Throttle.Failed(newCtx("10.0.0.1"), "user9@example.com"); err != ErrTooManyRequests {
+		t.Fatalf("expected the ip to be locked, got %v", err)
+	}
+	if err := app.Auth.Throttle.Check(newCtx("10.0.0.1"), "new@example.com"); err != ErrTooManyRequests {
+		t.Errorf("expected the locked ip to be rejected for every username, got %v", err)
+	}
+
+	// Many ips that try one username
+	for i := 0; i < 2; i++ {
+		if err := app.Auth.Throttle.Failed(newCtx(fmt.Sprintf("10.0.1.%d", i)), "john@example.com"); err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i+1, err)
+		}
+	}
+	if err := app.Auth.Throttle.Failed(newCtx("10.0.1.9"), "john@example.com"); err != ErrTooManyRequests {
+		t.Fatalf("expected the username to be locked, got %v", err)
+	}
+	if err := app.Auth.Throttle.Check(newCtx("10.0.2.1"), "john@example.com"); err != ErrTooManyRequests {
+		t.Errorf("expected the locked username to be rejected from every ip, got %v", err)
+	}
+
+	if len(scopes) != 2 || scopes[0] != IPThrottleScope || scopes[1] != UsernameThrottleScope {
+		t.Errorf("expected an ip and a username lockout, got %v", scopes)
+	}
+}
+
+func TestLoginThrottleConfig_Validate(t *testing.T) {
+	for _, driver := range []ThrottleStoreDriver{"", MemoryThrottleStoreDriver, RedisThrottleStoreDriver} {
+		if err := (LoginThrottleConfig{Store: driver}).Validate(); err != nil {
+			t.Errorf("expected %q to be valid, got %v", driver, err)
+		}
+	}
+	if err := (LoginThrottleConfig{Store: "mongo"}).Validate(); err == nil {
+		t.Error("expected an unknown driver to be invalid")
+	}
+}
+
+func TestRedisThrottleStore_HitExpires(t *testing.T) {
+	addr := os.Getenv("DOJO_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("DOJO_TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	store := NewRedisThrottleStore(client)
+	store.Prefix = fmt.Sprintf("dojo:test:%d:", time.Now().UnixNano())
+	ctx := context.Background()
+	defer client.Del(ctx, store.Prefix+"key")
+
+	for i := 1; i <= 2; i++ {
+		if attempts, err := store.Hit(ctx, "key", time.Minute); err != nil || attempts != i {
+			t.Fatalf("expected %d attempts, got %d %v", i, attempts, err)
+		}
+	}
+	if ttl, err := client.PTTL(ctx, store.Prefix+"key").Result(); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the counter to expire within the decay window, got %s %v", ttl, err)
+	}
+}
@@ -0,0 +1,387 @@
+package dojo
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/skip2/go-qrcode"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod             = 30
+	totpDigits             = 6
+	recoveryCodeBytes      = 10
+	recoveryCodeGroup      = 4
+	twoFactorPendingKey    = "auth_two_factor_pending"
+	defaultTwoFactorIssuer = "dojo"
+)
+
+var (
+	ErrTwoFactorNotPending   = errors.New("no login is waiting for the second factor")
+	ErrInvalidEncryptedValue = errors.New("encrypted value is malformed")
+)
+
+// LoginState tells how far the user got in the login
+type LoginState string
+
+const (
+	LoginStateGuest            LoginState = "guest"
+	LoginStateTwoFactorPending LoginState = "two_factor_pending"
+	LoginStateAuthenticated    LoginState = "authenticated"
+)
+
+// TwoFactorAuthenticable can be implemented by the user data to tell if the
+// user enrolled in two factor authentication.
+type TwoFactorAuthenticable interface {
+	TwoFactorEnabled() bool
+}
+
+// TwoFactorStepStore remembers the last accepted time step of every user, so
+// a code can't be used twice, not even from another session.
+type TwoFactorStepStore interface {
+	// UseStep stores the step and reports true when it is newer than the last
+	// accepted step of the user. The step is kept for the ttl.
+	UseStep(ctx context.Context, userID string, step int64, ttl time.Duration) (bool, error)
+}
+
+// GenerateTOTPSecret generates a random base32 encoded secret for the enrollment
+func GenerateTOTPSecret() (string, error) {
+	b, err := generateRandomBytes(20)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth uri that authenticator apps read from the qr code
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", totpDigits))
+	q.Set("period", fmt.Sprintf("%d", totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, q.Encode())
+}
+
+// TOTPQRCode renders the otpauth uri as png qr code
+func TOTPQRCode(uri string, size int) ([]byte, error) {
+	return qrcode.Encode(uri, qrcode.Medium, size)
+}
+
+// TOTPCode returns the RFC 6238 code of the secret for the time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+// ValidateTOTP checks the code against the time steps within the window
+// around t and returns the matched time step.
+func ValidateTOTP(secret, code string, t time.Time, window int) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	step := t.Unix() / totpPeriod
+	for i := -window; i <= window; i++ {
+		s := step + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(s))), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func hotp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// GenerateRecoveryCodes returns the plain codes to show once to the user and
+// their hashes to store. A code carries 80 random bits, the hashes are keyed
+// with the encryption key so a leaked table can't be brute forced offline.
+func (auth *Authentication) GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b, err := generateRandomBytes(recoveryCodeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		var groups []string
+		for len(code) > 0 {
+			groups = append(groups, code[:recoveryCodeGroup])
+			code = code[recoveryCodeGroup:]
+		}
+		code = strings.Join(groups, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, auth.hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode checks the code against the hashes and returns the hashes
+// without the used one, a code can only be used once.
+func (auth *Authentication) UseRecoveryCode(code string, hashes []string) ([]string, bool) {
+	hash := auth.hashRecoveryCode(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			remaining := append([]string{}, hashes[:i]...)
+			return append(remaining, hashes[i+1:]...), true
+		}
+	}
+	return hashes, false
+}
+
+// hashRecoveryCode ignores the case, the spaces and the dashes the user typed
+func (auth *Authentication) hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	mac := hmac.New(sha256.New, []byte("recovery:"+auth.twoFactorConfig().EncryptionKey))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (auth *Authentication) twoFactorConfig() TwoFactorConfig {
+	cfg := auth.dojo.Configuration.Auth.TwoFactor
+	if cfg.Issuer == "" {
+		cfg.Issuer = auth.dojo.Configuration.App.Name
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = defaultTwoFactorIssuer
+	}
+	if cfg.EncryptionKey == "" {
+		cfg.EncryptionKey = auth.dojo.Configuration.Session.Secret
+	}
+	return cfg
+}
+
+// TwoFactorURI returns the otpauth uri for the account with the configured issuer
+func (auth *Authentication) TwoFactorURI(account, secret string) string {
+	return TOTPURI(auth.twoFactorConfig().Issuer, account, secret)
+}
+
+// EncryptTwoFactorSecret encrypts the secret with AES-GCM to store it at rest
+func (auth *Authentication) EncryptTwoFactorSecret(secret string) (string, error) {
+	gcm, err := auth.twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateRandomBytes(uint32(gcm.NonceSize()))
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (auth *Authentication) DecryptTwoFactorSecret(encrypted string) (string, error) {
+	gcm, err := auth.twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(raw) < gcm.NonceSize() {
+		return "", ErrInvalidEncryptedValue
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (auth *Authentication) twoFactorCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(auth.twoFactorConfig().EncryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoginPendingTwoFactor marks the password as verified. The user is not
+// authenticated before the second factor is verified.
+func (auth *Authentication) LoginPendingTwoFactor(ctx Context, user Authenticable) error {
+	session := ctx.Session()
+	session.Set(twoFactorPendingKey, NewAuthUser(user))
+	return session.Save()
+}
+
+// PendingTwoFactorUser returns the user that waits for the second factor
+func (auth *Authentication) PendingTwoFactorUser(ctx Context) (AuthUser, bool) {
+	session := ctx.Session()
+	user, ok := session.Get(twoFactorPendingKey).(AuthUser)
+	return user, ok
+}
+
+// LoginState returns the login state of the current request
+func (auth *Authentication) LoginState(ctx Context) LoginState {
+	if user := auth.GetAuthUser(ctx); !user.IsGuest() {
+		return LoginStateAuthenticated
+	}
+	if _, ok := auth.PendingTwoFactorUser(ctx); ok {
+		return LoginStateTwoFactorPending
+	}
+	return LoginStateGuest
+}
+
+// VerifyTwoFactor validates the code against the encrypted secret and completes
+// the pending login. A code can't be used twice. The failed codes count
+// against the login throttle of the user, ErrTooManyRequests is returned
+// once the user or the ip is locked.
+func (auth *Authentication) VerifyTwoFactor(ctx Context, encryptedSecret, code string) (bool, error) {
+	user, ok := auth.PendingTwoFactorUser(ctx)
+	if !ok {
+		return false, ErrTwoFactorNotPending
+	}
+	key := twoFactorThrottleKey(user)
+	if err := auth.Throttle.Check(ctx, key); err != nil {
+		return false, err
+	}
+	secret, err := auth.DecryptTwoFactorSecret(encryptedSecret)
+	if err != nil {
+		return false, err
+	}
+
+	window := auth.twoFactorConfig().Window
+	step, valid := ValidateTOTP(secret, code, time.Now(), window)
+	if !valid {
+		return false, auth.Throttle.Failed(ctx, key)
+	}
+
+	// The step is remembered until no code of it is accepted anymore
+	ttl := time.Duration(2*window+2) * totpPeriod * time.Second
+	fresh, err := auth.TwoFactorSteps.UseStep(ctx, user.GetAuthIdentifier().String(), step, ttl)
+	if err != nil {
+		return false, err
+	}
+	if !fresh {
+		return false, auth.Throttle.Failed(ctx, key)
+	}
+
+	if err := auth.Throttle.Succeeded(ctx, key); err != nil {
+		return false, err
+	}
+	return true, auth.completeTwoFactor(ctx, user)
+}
+
+// VerifyRecoveryCode completes the pending login with a recovery code and
+// returns the remaining hashes the application has to store. It is
+// throttled like VerifyTwoFactor.
+func (auth *Authentication) VerifyRecoveryCode(ctx Context, code string, hashes []string) ([]string, bool, error) {
+	user, ok := auth.PendingTwoFactorUser(ctx)
+	if !ok {
+		return hashes, false, ErrTwoFactorNotPending
+	}
+	key := twoFactorThrottleKey(user)
+	if err := auth.Throttle.Check(ctx, key); err != nil {
+		return hashes, false, err
+	}
+	remaining, valid := auth.UseRecoveryCode(code, hashes)
+	if !valid {
+		return hashes, false, auth.Throttle.Failed(ctx, key)
+	}
+	if err := auth.Throttle.Succeeded(ctx, key); err != nil {
+		return hashes, false, err
+	}
+	return remaining, true, auth.completeTwoFactor(ctx, user)
+}
+
+// twoFactorThrottleKey counts the second factor apart from the password of
+// the user, both are throttled with the same limits.
+func twoFactorThrottleKey(user AuthUser) string {
+	return "two_factor:" + user.GetAuthIdentifier().String()
+}
+
+func (auth *Authentication) completeTwoFactor(ctx Context, user AuthUser) error {
+	session := ctx.Session()
+	session.Delete(twoFactorPendingKey)
+	return auth.Login(ctx, &user)
+}
+
+type memoryTwoFactorStep struct {
+	step      int64
+	expiresAt time.Time
+}
+
+// MemoryTwoFactorStepStore keeps the accepted steps in the memory of the process.
+type MemoryTwoFactorStepStore struct {
+	mu    sync.Mutex
+	steps map[string]memoryTwoFactorStep
+}
+
+func NewMemoryTwoFactorStepStore() *MemoryTwoFactorStepStore {
+	return &MemoryTwoFactorStepStore{steps: make(map[string]memoryTwoFactorStep)}
+}
+
+func (s *MemoryTwoFactorStepStore) UseStep(_ context.Context, userID string, step int64, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range s.steps {
+		if now.After(e.expiresAt) {
+			delete(s.steps, id)
+		}
+	}
+	if last, ok := s.steps[userID]; ok && step <= last.step {
+		return false, nil
+	}
+	s.steps[userID] = memoryTwoFactorStep{step: step, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// RedisTwoFactorStepStore keeps the accepted steps in redis so they are shared between instances.
+type RedisTwoFactorStepStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func NewRedisTwoFactorStepStore(client *redis.Client) *RedisTwoFactorStepStore {
+	return &RedisTwoFactorStepStore{Client: client, Prefix: "dojo:two_factor:"}
+}
+
+var useTwoFactorStepScript = redis.NewScript(`
+local last = tonumber(redis.call("GET", KEYS[1]))
+if last and tonumber(ARGV[1]) <= last then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+func (s *RedisTwoFactorStepStore) UseStep(ctx context.Context, userID string, step int64, ttl time.Duration) (bool, error) {
+	fresh, err := useTwoFactorStepScript.Run(ctx, s.Client, []string{s.Prefix + userID}, step, ttl.Milliseconds()).Int()
+	return fresh == 1, err
+}
@@ -0,0 +1,216 @@
+package dojo
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The secret of the RFC 6238 test vectors, base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP_Window(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	previous, _ := TOTPCode(rfc6238Secret, now.Add(-30*time.Second))
+
+	if _, ok := ValidateTOTP(rfc6238Secret, previous, now, 1); !ok {
+		t.Error("the code of the previous time step must be accepted within the window")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, previous, now, 0); ok {
+		t.Error("the code of the previous time step must be rejected without a window")
+	}
+}
+
+func TestAuthentication_UseRecoveryCode(t *testing.T) {
+	app := New(DefaultConfiguration{Session: SessionConfig{Secret: "secret"}})
+	codes, hashes, err := app.Auth.GenerateRecoveryCodes(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes[0]) != 19 {
+		t.Errorf("expected 4 groups of 4 base32 characters, got %q", codes[0])
+	}
+
+	remaining, ok := app.Auth.UseRecoveryCode(strings.ToUpper(codes[3]), hashes)
+	if !ok || len(remaining) != 7 {
+		t.Fatalf("expected the code to be used once, ok=%v remaining=%d", ok, len(remaining))
+	}
+	if _, ok := app.Auth.UseRecoveryCode(codes[3], remaining); ok {
+		t.Error("a recovery code must not be usable twice")
+	}
+
+	other := New(DefaultConfiguration{Session: SessionConfig{Secret: "other"}})
+	if _, ok := other.Auth.UseRecoveryCode(codes[0], hashes); ok {
+		t.Error("the hashes must be keyed with the encryption key")
+	}
+}
+
+func TestAuthentication_EncryptTwoFactorSecret(t *testing.T) {
+	app := New(DefaultConfiguration{Session: SessionConfig{Secret: "secret"}})
+
+	encrypted, err := app.Auth.EncryptTwoFactorSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted == rfc6238Secret {
+		t.Fatal("the secret must not be stored in plain text")
+	}
+	decrypted, err := app.Auth.DecryptTwoFactorSecret(encrypted)
+	if err != nil || decrypted != rfc6238Secret {
+		t.Errorf("expected the secret back, got %q %v", decrypted, err)
+	}
+}
+
+func TestAuthentication_VerifyTwoFactor(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+		// The window keeps the test stable when a time step ends during the test
+		Auth: AuthenticationConfig{TwoFactor: TwoFactorConfig{Window: 1}},
+	})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	encrypted, err := app.Auth.EncryptTwoFactorSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+
+	app.Route.Get("/login", func(ctx Context) error {
+		return ctx.Dojo().Auth.LoginPendingTwoFactor(ctx, user)
+	})
+	app.Route.Get("/challenge", func(ctx Context) error {
+		ok, err := ctx.Dojo().Auth.VerifyTwoFactor(ctx, encrypted, ctx.Request().URL.Query().Get("code"))
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, ok)
+	})
+
+	// login starts a new session that waits for the second factor
+	login := func() []*http.Cookie {
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+		return rec.Result().Cookies()
+	}
+	challenge := func(cookies []*http.Cookie, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/challenge?code="+code, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := challenge(nil, "000000"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected an error without a pending login, got %d", rec.Code)
+	}
+
+	code, _ := TOTPCode(rfc6238Secret, time.Now())
+	if rec := challenge(login(), code); rec.Body.String() != `{"data":true}` {
+		t.Fatalf("expected the code to be accepted, got %s", rec.Body.String())
+	}
+	// The code was seen, a second session of the same user can't use it again
+	if rec := challenge(login(), code); rec.Body.String() != `{"data":false}` {
+		t.Errorf("expected a replayed code to be rejected, got %s", rec.Body.String())
+	}
+}
+
+func TestMemoryTwoFactorStepStore(t *testing.T) {
+	store := NewMemoryTwoFactorStepStore()
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		user  string
+		step  int64
+		fresh bool
+	}{
+		{"1", 10, true},
+		{"1", 10, false},
+		{"1", 9, false},
+		{"2", 10, true},
+		{"1", 11, true},
+	} {
+		fresh, err := store.UseStep(ctx, c.user, c.step, time.Minute)
+		if err != nil || fresh != c.fresh {
+			t.Errorf("user %s step %d: expected %v, got %v %v", c.user, c.step, c.fresh, fresh, err)
+		}
+	}
+
+	if fresh, _ := store.UseStep(ctx, "3", 5, -time.Second); !fresh {
+		t.Fatal("expected the first step of a user to be fresh")
+	}
+	if fresh, _ := store.UseStep(ctx, "3", 4, time.Minute); !fresh {
+		t.Error("expected an expired step to be forgotten")
+	}
+}
+
+func TestAuthentication_VerifyRecoveryCodeThrottled(t *testing.T) {
+	app := New(DefaultConfiguration{
+		Session: SessionConfig{Name: "dojo_session", Secret: "0123456789abcdef0123456789abcdef"},
+		Auth:    AuthenticationConfig{Throttle: LoginThrottleConfig{MaxAttempts: 3}},
+	})
+	app.HTTPErrorHandler = app.DefaultHTTPErrorHandler
+	codes, hashes, err := app.Auth.GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &AuthUser{Identifier: UUIDIdentifier(uuid.Must(uuid.NewV4()))}
+
+	app.Route.Get("/login", func(ctx Context) error {
+		return ctx.Dojo().Auth.LoginPendingTwoFactor(ctx, user)
+	})
+	app.Route.Get("/recover", func(ctx Context) error {
+		_, ok, err := ctx.Dojo().Auth.VerifyRecoveryCode(ctx, ctx.Request().URL.Query().Get("code"), hashes)
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, ok)
+	})
+
+	rec := httptest.NewRecorder()
+	app.Route.GetMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := rec.Result().Cookies()
+	verify := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/recover?code="+code, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		app.Route.GetMux().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := verify("wrong"); rec.Body.String() != `{"data":false}` {
+			t.Fatalf("attempt %d: expected the code to be rejected, got %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := verify("wrong"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the user to be locked after 3 attempts, got %d", rec.Code)
+	}
+	if rec := verify(codes[0]); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a valid code to be refused while locked, got %d", rec.Code)
+	}
+}
@@ -12,9 +12,14 @@ import (
 type ViewAdditionalData map[string]interface{}
 
 type ViewData struct {
+	// Assets carry the csp nonce of the request, render them with {{ .Tag }}.
+	// A tag written from the path in the template needs nonce="{{ cspNonce }}"
+	// when the policy uses a nonce.
 	Assets []Asset
 	User   Authenticable
-	Data   map[string]interface{}
+	// Impersonating is true when the user is impersonated by another user
+	Impersonating bool
+	Data          map[string]interface{}
 }
 
 func csrfValue(ctx Context) func() string {
@@ -24,12 +29,24 @@ func csrfValue(ctx Context) func() string {
 	}
 }
 
+func cspNonce(ctx Context) func() string {
+	return func() string {
+		return ctx.CSPNonce()
+	}
+}
+
 func activeRoute(ctx Context) func(route string) bool {
 	return func(route string) bool {
 		return mux.CurrentRoute(ctx.Request()).GetName() == route
 	}
 }
 
+func can(ctx Context) func(ability string, args ...interface{}) bool {
+	return func(ability string, args ...interface{}) bool {
+		return ctx.Can(ability, args...)
+	}
+}
+
 func route(dojo *Dojo) func(name string, args ...string) string {
 	muxRouter := dojo.Route.GetMux()
 	return func(name string, args ...string) string {
@@ -54,7 +71,9 @@ func (ctx *DefaultContext) View(viewName string, data ViewAdditionalData) error
 	d := ctx.dojo
 	var functions = sprig.FuncMap()
 	functions["csrf"] = csrfValue(ctx)
+	functions["cspNonce"] = cspNonce(ctx)
 	functions["activeRoute"] = activeRoute(ctx)
+	functions["can"] = can(ctx)
 	functions["route"] = route(d)
 	functions["markdown"] = markdown
 	functions["saveHTML"] = saveHTML
@@ -78,9 +97,10 @@ func (ctx *DefaultContext) View(viewName string, data ViewAdditionalData) error
 
 	user := d.Auth.GetAuthUser(ctx)
 	viewData := ViewData{
-		Assets: d.Assets(),
-		User:   &user,
-		Data:   data,
+		Assets:        withNonce(d.Assets(), ctx.CSPNonce()),
+		User:          &user,
+		Impersonating: d.Auth.IsImpersonating(ctx),
+		Data:          data,
 	}
 
 	err = ts.Execute(ctx.Response(), viewData)